@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/walter-cd/walter/lib/pipeline"
+)
+
+// runConfig runs "walter config", which shows the pipeline definition.
+func runConfig(args []string) int {
+	var (
+		configFile string
+		resolved   bool
+		offline    bool
+	)
+
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", defaultConfigFile, "file which define pipeline")
+	flags.BoolVar(&resolved, "resolved", false, "print the definition merged with the definitions it extends")
+	flags.BoolVar(&offline, "offline", false, "read remote includes from the cache only")
+	flags.Parse(args)
+
+	if !resolved {
+		log.Error("specify -resolved flag")
+		return 1
+	}
+
+	pipeline.Offline = offline
+
+	// Load the definition to report errors
+	if _, err := pipeline.LoadFromFile(configFile); err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	b, err := pipeline.Resolve(configFile)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	fmt.Fprint(os.Stdout, string(b))
+	return 0
+}
@@ -0,0 +1,207 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"golang.org/x/net/context"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// Manager serializes tasks sharing named locks. Locks are held in-process
+// and through flock'd files in Dir, so that separate walter processes on the
+// same host are serialized too.
+type Manager struct {
+	Dir   string
+	mu    sync.Mutex
+	locks map[string]*lock
+}
+
+type lock struct {
+	ch     chan struct{}
+	mu     sync.Mutex
+	holder string
+}
+
+func NewManager(dir string) *Manager {
+	return &Manager{Dir: dir, locks: map[string]*lock{}}
+}
+
+// DefaultDir returns the lock directory used when none is configured.
+func DefaultDir() string {
+	return filepath.Join(os.TempDir(), "walter-locks")
+}
+
+// Acquire acquires the named locks for owner. It waits at most timeout for
+// each lock, or forever if timeout is zero. The returned function releases
+// all the locks.
+func (m *Manager) Acquire(ctx context.Context, owner string, names []string, timeout time.Duration) (func(), error) {
+	names = append([]string{}, names...)
+	// Always acquire locks in the same order to avoid deadlocks
+	sort.Strings(names)
+	names = unique(names)
+
+	var releases []func()
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	for _, name := range names {
+		r, err := m.acquire(ctx, owner, name, timeout)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+
+	return release, nil
+}
+
+// unique removes repeated names from sorted names, which would otherwise
+// wait for themselves.
+func unique(names []string) []string {
+	var u []string
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			u = append(u, name)
+		}
+	}
+	return u
+}
+
+func (m *Manager) acquire(ctx context.Context, owner, name string, timeout time.Duration) (func(), error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("lock %q: invalid lock name", name)
+	}
+
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	l := m.get(name)
+	select {
+	case l.ch <- struct{}{}:
+	default:
+		log.Infof("[%s] Waiting for lock %s held by %s", owner, name, l.getHolder())
+		select {
+		case l.ch <- struct{}{}:
+		case <-ctx.Done():
+			return nil, waitError(parent, name, l.getHolder())
+		}
+	}
+	l.setHolder(owner)
+
+	f, err := m.flock(ctx, parent, owner, name)
+	if err != nil {
+		<-l.ch
+		return nil, err
+	}
+
+	log.Infof("[%s] Acquired lock %s", owner, name)
+
+	return func() {
+		f.Truncate(0)
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+		l.setHolder("")
+		<-l.ch
+		log.Infof("[%s] Released lock %s", owner, name)
+	}, nil
+}
+
+func (m *Manager) get(name string) *lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.locks[name]
+	if !ok {
+		l = &lock{ch: make(chan struct{}, 1)}
+		m.locks[name] = l
+	}
+	return l
+}
+
+func (m *Manager) flock(ctx, parent context.Context, owner, name string) (*os.File, error) {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(m.Dir, name+".lock")
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	logged := false
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, err
+		}
+
+		if !logged {
+			log.Infof("[%s] Waiting for lock %s held by %s", owner, name, readHolder(file))
+			logged = true
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			f.Close()
+			return nil, waitError(parent, name, readHolder(file))
+		}
+	}
+
+	host, _ := os.Hostname()
+	f.Truncate(0)
+	f.WriteAt([]byte(fmt.Sprintf("%s (pid %d on %s)", owner, os.Getpid(), host)), 0)
+
+	return f, nil
+}
+
+func waitError(parent context.Context, name, holder string) error {
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	return errors.New("timed out waiting for lock " + name + " held by " + holder)
+}
+
+func readHolder(file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil || len(data) == 0 {
+		return "another process"
+	}
+	return string(data)
+}
+
+func (l *lock) getHolder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.holder
+}
+
+func (l *lock) setHolder(holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holder = holder
+}
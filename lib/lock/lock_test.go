@@ -0,0 +1,138 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestAcquireInProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager(dir)
+	release, err := m.Acquire(context.Background(), "first", []string{"db"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Acquire(context.Background(), "second", []string{"db"}, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("Acquire should time out while the lock is held")
+	}
+
+	if !strings.Contains(err.Error(), "first") {
+		t.Fatalf("error should contain the holder of the lock: %s", err)
+	}
+
+	release()
+
+	release, err = m.Acquire(context.Background(), "second", []string{"db"}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestAcquireAcrossProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "deploy.lock")
+	err = ioutil.WriteFile(file, []byte("other walter"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(dir)
+	_, err = m.Acquire(context.Background(), "task", []string{"deploy"}, 300*time.Millisecond)
+	if err == nil {
+		t.Fatal("Acquire should time out while the lock file is locked")
+	}
+
+	if !strings.Contains(err.Error(), "other walter") {
+		t.Fatalf("error should contain the holder of the lock: %s", err)
+	}
+
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	release, err := m.Acquire(context.Background(), "task", []string{"deploy"}, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager(dir)
+	release, err := m.Acquire(context.Background(), "first", []string{"db"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = m.Acquire(ctx, "second", []string{"db"}, 0)
+	if err != context.Canceled {
+		t.Fatalf("Acquire should return context.Canceled, not %v", err)
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	m := NewManager(os.TempDir())
+	_, err := m.Acquire(context.Background(), "task", []string{"../db"}, 0)
+	if err == nil {
+		t.Fatal("Acquire should fail with an invalid lock name")
+	}
+}
+
+func TestRepeatedName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager(dir)
+	release, err := m.Acquire(context.Background(), "task", []string{"db", "db"}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	release, err = m.Acquire(context.Background(), "task", []string{"db"}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -52,16 +52,40 @@ func (s Slack) Notify(t *task.Task) error {
 		message = fmt.Sprintf("[%s] Succeeded", t.Name)
 		color = "good"
 	case task.Failed:
-		message = fmt.Sprintf("[%s] Failed", t.Name)
+		reason := fmt.Sprintf("exit code %d", t.ExitCode)
+		if t.Signal != "" {
+			reason = "killed by " + t.Signal
+		}
+		if t.Detail != "" {
+			reason = t.Detail
+		}
+		message = fmt.Sprintf("[%s] Failed (%s)", t.Name, reason)
 		color = "danger"
 	case task.Skipped:
 		message = fmt.Sprintf("[%s] Skipped", t.Name)
+		if t.Detail != "" {
+			message = fmt.Sprintf("[%s] Skipped (%s)", t.Name, t.Detail)
+		}
 		color = "warning"
+	case task.Stalled:
+		message = fmt.Sprintf("[%s] Stalled (%s)", t.Name, t.Detail)
+		color = "danger"
 	case task.Aborted:
 		message = fmt.Sprintf("[%s] Aborted", t.Name)
+		if t.Detail != "" {
+			message = fmt.Sprintf("[%s] Aborted (%s)", t.Name, t.Detail)
+		}
 		color = "warning"
 	}
 
+	if t.StripANSI {
+		message = task.StripANSI(message)
+	}
+
+	if len(t.Warnings) > 0 {
+		message = fmt.Sprintf("%s with %d warnings", message, len(t.Warnings))
+	}
+
 	a := attachment{
 		Text:  message,
 		Color: color,
@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/go-yaml/yaml"
+)
+
+// maxExtendsDepth limits how many definitions can extend each other.
+const maxExtendsDepth = 16
+
+// taskLists are the task lists which a definition can merge into the task
+// lists of the definition it extends with prepend, append and replace.
+var taskLists = []string{"build.tasks", "build.cleanup", "deploy.tasks", "deploy.cleanup"}
+
+// Resolve returns the pipeline definition in file merged with the
+// definitions it extends.
+func Resolve(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRemote(file)
+	if err != nil {
+		return nil, err
+	}
+	return newLoader(Templates{}, r).resolveExtends(data, file)
+}
+
+// resolveExtends merges the definition in b with the definition it extends.
+// The path of extends is relative to file, which contains b, or to the
+// working directory when file is empty. extends can also be a URL.
+func (l *loader) resolveExtends(b []byte, file string) ([]byte, error) {
+	var chain []string
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		file, chain = abs, []string{abs}
+	}
+
+	var child map[interface{}]interface{}
+	if err := yaml.Unmarshal(b, &child); err != nil || child["extends"] == nil {
+		// Not extending, or a list of tasks
+		return b, nil
+	}
+
+	merged, err := l.extend(child, file, chain)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+func (l *loader) extend(child map[interface{}]interface{}, file string, chain []string) (map[interface{}]interface{}, error) {
+	ref, ok := child["extends"].(string)
+	if !ok {
+		return nil, fmt.Errorf("extends must be a path%s", formatChain(chain, " in "))
+	}
+	delete(child, "extends")
+
+	path, err := resolveLocation(expandEnv(ref), file)
+	if err != nil {
+		return nil, fmt.Errorf("extends %s: %s", ref, err)
+	}
+	if !isRemote(path) {
+		if path, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+	}
+
+	next := append(append([]string{}, chain...), path)
+	if includes(chain, path) {
+		return nil, fmt.Errorf("extends cycle: %s", formatChain(next, ""))
+	}
+	if len(chain) > maxExtendsDepth {
+		return nil, fmt.Errorf("extends nested deeper than %d: %s", maxExtendsDepth, formatChain(next, ""))
+	}
+
+	data, err := l.read(path)
+	if err != nil {
+		return nil, fmt.Errorf("extends %s: %s", ref, err)
+	}
+	var base map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("extends %s: %s", ref, err)
+	}
+	if base["extends"] != nil {
+		if base, err = l.extend(base, path, next); err != nil {
+			return nil, err
+		}
+	}
+
+	// Includes of the base are relative to the base
+	rebaseIncludes(base, path)
+
+	merged, err := merge(base, child, "")
+	if err != nil {
+		return nil, fmt.Errorf("extends %s: %s", ref, err)
+	}
+	return merged.(map[interface{}]interface{}), nil
+}
+
+// merge deep merges child into base. Mappings are merged key by key, and
+// other values of child replace those of base. Task lists of child can be a
+// mapping of tasks to prepend, append or replace by name.
+func merge(base, child interface{}, path string) (interface{}, error) {
+	if includes(taskLists, path) {
+		if rules, ok := child.(map[interface{}]interface{}); ok {
+			return mergeTasks(base, rules, path)
+		}
+	}
+
+	b, ok1 := base.(map[interface{}]interface{})
+	c, ok2 := child.(map[interface{}]interface{})
+	if !ok1 || !ok2 {
+		return child, nil
+	}
+
+	merged := map[interface{}]interface{}{}
+	for k, v := range b {
+		merged[k] = v
+	}
+	for k, v := range c {
+		key := fmt.Sprint(k)
+		if path != "" {
+			key = path + "." + key
+		}
+
+		m, err := merge(b[k], v, key)
+		if err != nil {
+			return nil, err
+		}
+		merged[k] = m
+	}
+	return merged, nil
+}
+
+func mergeTasks(base interface{}, rules map[interface{}]interface{}, path string) (interface{}, error) {
+	tasks, ok := base.([]interface{})
+	if !ok && base != nil {
+		return nil, fmt.Errorf("%s is not a list of tasks", path)
+	}
+	tasks = append([]interface{}{}, tasks...)
+
+	var prepended, appended, replaced []interface{}
+	for k, v := range rules {
+		list, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, fmt.Errorf("%s.%v must be a list of tasks", path, k)
+		}
+		switch k {
+		case "prepend":
+			prepended = list
+		case "append":
+			appended = list
+		case "replace":
+			replaced = list
+		default:
+			return nil, fmt.Errorf("%s does not support %v, use prepend, append or replace", path, k)
+		}
+	}
+
+	for _, r := range replaced {
+		name := taskName(r)
+		i := indexOfTask(tasks, name)
+		if name == "" || i < 0 {
+			return nil, fmt.Errorf("%s has no task named %q to replace", path, name)
+		}
+		tasks[i] = r
+	}
+
+	merged := append([]interface{}{}, prepended...)
+	merged = append(merged, tasks...)
+	return append(merged, appended...), nil
+}
+
+func taskName(t interface{}) string {
+	if m, ok := t.(map[interface{}]interface{}); ok {
+		if name, ok := m["name"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func indexOfTask(tasks []interface{}, name string) int {
+	for i, t := range tasks {
+		if taskName(t) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// rebaseIncludes makes relative paths of includes in v relative to file.
+func rebaseIncludes(v interface{}, file string) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			rebaseIncludes(e, file)
+		}
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && k == "include" {
+				if ref := expandEnv(s); !isRemote(ref) && !filepath.IsAbs(ref) {
+					if location, err := resolveLocation(s, file); err == nil {
+						v[k] = location
+					}
+				}
+				continue
+			}
+			rebaseIncludes(e, file)
+		}
+	}
+}
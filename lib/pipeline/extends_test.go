@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtends(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base/pipeline.yml": `
+fail_fast: false
+lock_dir: /tmp/locks
+templates:
+  greet:
+    params:
+      who:
+    tasks:
+      - command: echo hello {{ who }}
+build:
+  tasks:
+    - name: lint
+      command: make lint
+    - name: test
+      command: make test
+    - include: tasks.yml
+  cleanup:
+    - name: clean
+      command: make clean
+`,
+		"base/tasks.yml": `
+- name: package
+  command: make package
+`,
+		"service/pipeline.yml": `
+extends: ../base/pipeline.yml
+lock_dir: /tmp/service-locks
+build:
+  tasks:
+    prepend:
+      - name: setup
+        command: make setup
+    replace:
+      - name: test
+        command: make test-all
+    append:
+      - use: greet
+        with:
+          who: service
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := LoadFromFile(filepath.Join(dir, "service", "pipeline.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.LockDir != "/tmp/service-locks" || p.FailFast == nil || *p.FailFast {
+		t.Fatalf("Settings are not merged: %#v", p)
+	}
+
+	var names []string
+	for _, task := range p.Build.Tasks {
+		names = append(names, task.Name+":"+task.Command)
+	}
+	expected := "setup:make setup,lint:make lint,test:make test-all,package:make package,:echo hello service"
+	if strings.Join(names, ",") != expected {
+		t.Fatalf("Tasks are not merged: %v", names)
+	}
+
+	if len(p.Build.Cleanup) != 1 || p.Build.Cleanup[0].Name != "clean" {
+		t.Fatalf("Cleanup tasks of the base should be kept: %#v", p.Build.Cleanup)
+	}
+}
+
+func TestExtendsReplacesList(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.yml":  "build:\n  tasks:\n    - name: a\n      command: echo a\n",
+		"child.yml": "extends: base.yml\nbuild:\n  tasks:\n    - name: b\n      command: echo b\n",
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := LoadFromFile(filepath.Join(dir, "child.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Build.Tasks) != 1 || p.Build.Tasks[0].Name != "b" {
+		t.Fatalf("A list of tasks should replace the tasks of the base: %#v", p.Build.Tasks)
+	}
+}
+
+func TestExtendsErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yml":       "extends: b.yml\n",
+		"b.yml":       "extends: a.yml\n",
+		"base.yml":    "build:\n  tasks:\n    - name: a\n      command: echo a\n",
+		"replace.yml": "extends: base.yml\nbuild:\n  tasks:\n    replace:\n      - name: b\n        command: echo b\n",
+		"unknown.yml": "extends: base.yml\nbuild:\n  tasks:\n    insert:\n      - name: b\n",
+	})
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"a.yml":       "extends cycle: ",
+		"replace.yml": `build.tasks has no task named "b" to replace`,
+		"unknown.yml": "build.tasks does not support insert",
+	}
+	for file, expected := range cases {
+		_, err := LoadFromFile(filepath.Join(dir, file))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("%s: error should contain %q: %v", file, expected, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// maxIncludeDepth limits how deeply included files can include other files.
+const maxIncludeDepth = 16
+
+// loader reads the files which a pipeline definition includes and extends.
+type loader struct {
+	templates Templates
+	remote    *remote
+}
+
+// newLoader returns a loader which adds templates of included files to
+// templates and fetches remote files with r, or without a lock file when r
+// is nil.
+func newLoader(templates Templates, r *remote) *loader {
+	if r == nil {
+		r, _ = newRemote("")
+	}
+	return &loader{templates: templates, remote: r}
+}
+
+// read returns the content of the local or remote file.
+func (l *loader) read(file string) ([]byte, error) {
+	if isRemote(file) {
+		return l.remote.fetch(file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// resolveIncludes replaces include tasks, also in parallel and serial tasks,
+// with the tasks of the included files. Paths of includes are relative to
+// file, which contains the tasks, or to the working directory when file is
+// empty. chain is the list of files including file. Templates defined in
+// included files are added to the templates of the loader.
+func (l *loader) resolveIncludes(tasks Tasks, file string, chain []string) (Tasks, error) {
+	var resolved Tasks
+	for _, t := range tasks {
+		if t.Include != "" {
+			include, err := l.includeTasks(t.Include, file, chain)
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, include...)
+			continue
+		}
+
+		var err error
+		if t.Parallel, err = l.resolveIncludes(t.Parallel, file, chain); err != nil {
+			return nil, err
+		}
+		if t.Serial, err = l.resolveIncludes(t.Serial, file, chain); err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, t)
+	}
+	return resolved, nil
+}
+
+// includedFile is an included file which defines templates besides tasks.
+type includedFile struct {
+	Templates Templates
+	Tasks     Tasks
+}
+
+// includeTasks reads the tasks of the files matching pattern in lexical
+// order, or of the remote file at the URL. An included file is either a list
+// of tasks or an includedFile.
+func (l *loader) includeTasks(pattern, from string, chain []string) (Tasks, error) {
+	pattern = expandEnv(pattern)
+	if isRemote(pattern) || isRemote(from) {
+		location, err := resolveLocation(pattern, from)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %s", pattern, err)
+		}
+		return l.includeFile(location, chain)
+	}
+
+	if from != "" && !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(from), pattern)
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %s", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("include %s: no such file%s", pattern, formatChain(chain, " in "))
+	}
+
+	var tasks Tasks
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+
+		include, err := l.includeFile(abs, chain)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, include...)
+	}
+	return tasks, nil
+}
+
+// includeFile reads the tasks of file, which is an absolute path or a URL.
+func (l *loader) includeFile(file string, chain []string) (Tasks, error) {
+	next := append(append([]string{}, chain...), file)
+	if includes(chain, file) {
+		return nil, fmt.Errorf("include cycle: %s", formatChain(next, ""))
+	}
+	if len(chain) > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested deeper than %d: %s", maxIncludeDepth, formatChain(next, ""))
+	}
+
+	data, err := l.read(file)
+	if err != nil {
+		return nil, err
+	}
+
+	include := Tasks{}
+	if err := yaml.Unmarshal(data, &include); err != nil {
+		included := includedFile{}
+		if err := yaml.Unmarshal(data, &included); err != nil {
+			return nil, fmt.Errorf("include %s: %s", file, err)
+		}
+		if err := checkIncludedKeys(data); err != nil {
+			return nil, fmt.Errorf("include %s: %s", file, err)
+		}
+		if err := l.templates.add(included.Templates, file); err != nil {
+			return nil, err
+		}
+		include = included.Tasks
+	}
+
+	return l.resolveIncludes(include, file, next)
+}
+
+// checkIncludedKeys checks that an included mapping has templates or tasks,
+// and nothing else, so that a misspelled key or a pipeline definition is not
+// read as a file without tasks.
+func checkIncludedKeys(data []byte) error {
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for k := range keys {
+		if k != "templates" && k != "tasks" {
+			return fmt.Errorf("unknown key %s, expected a list of tasks, or templates and tasks", k)
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("expected a list of tasks, or templates and tasks")
+	}
+	return nil
+}
+
+// formatChain returns the chain of included files relative to the working
+// directory, prefixed with prefix.
+func formatChain(chain []string, prefix string) string {
+	if len(chain) == 0 {
+		return ""
+	}
+
+	wd, _ := os.Getwd()
+	var files []string
+	for _, f := range chain {
+		if rel, err := filepath.Rel(wd, f); err == nil && !strings.HasPrefix(rel, "..") {
+			f = rel
+		}
+		files = append(files, f)
+	}
+	return prefix + strings.Join(files, " -> ")
+}
+
+func includes(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "walter-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIncludeRelativeToFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pipeline.yml": `
+build:
+  tasks:
+    - name: first
+      command: echo first
+    - include: ci/tasks/*.yml
+    - name: parallel
+      parallel:
+        - include: ci/common.yml
+`,
+		"ci/tasks/a.yml": `
+- name: a
+  command: echo a
+- include: ../common.yml
+`,
+		"ci/tasks/b.yml": `
+- name: b
+  command: echo b
+`,
+		"ci/common.yml": `
+- name: common
+  command: echo common
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, task := range p.Build.Tasks {
+		names = append(names, task.Name)
+	}
+	if strings.Join(names, ",") != "first,a,common,b,parallel" {
+		t.Fatalf("Includes are not resolved: %v", names)
+	}
+	if len(p.Build.Tasks[4].Parallel) != 1 || p.Build.Tasks[4].Parallel[0].Name != "common" {
+		t.Fatalf("Includes in parallel tasks are not resolved: %#v", p.Build.Tasks[4].Parallel)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pipeline.yml": "build:\n  tasks:\n    - include: x.yml\n",
+		"x.yml":        "- include: y.yml\n",
+		"y.yml":        "- name: y\n  command: echo y\n- include: x.yml\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+	if err == nil {
+		t.Fatal("Error should be returned")
+	}
+	chain := []string{"pipeline.yml", "x.yml", "y.yml", "x.yml"}
+	for i, f := range chain {
+		chain[i] = filepath.Join(dir, f)
+	}
+	if err.Error() != "include cycle: "+strings.Join(chain, " -> ") {
+		t.Fatalf("Error should show the include chain: %s", err)
+	}
+}
+
+func TestIncludeDepth(t *testing.T) {
+	files := map[string]string{"pipeline.yml": "build:\n  tasks:\n    - include: 0.yml\n"}
+	for i := 0; i <= maxIncludeDepth+1; i++ {
+		files[fmt.Sprintf("%d.yml", i)] = fmt.Sprintf("- include: %d.yml\n", i+1)
+	}
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+
+	_, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+	if err == nil || !strings.Contains(err.Error(), "nested deeper than") {
+		t.Fatalf("Error should be returned: %v", err)
+	}
+}
+
+func TestIncludeMissingFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pipeline.yml": "build:\n  tasks:\n    - include: ci/*.yml\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Fatalf("Error should be returned: %v", err)
+	}
+}
+
+func TestIncludeUnknownKeys(t *testing.T) {
+	for _, content := range []string{
+		"build:\n  tasks:\n    - name: build\n      command: make\n",
+		"task:\n  - name: build\n    command: make\n",
+	} {
+		dir := writeFiles(t, map[string]string{
+			"pipeline.yml": "build:\n  tasks:\n    - include: tasks.yml\n",
+			"tasks.yml":    content,
+		})
+		defer os.RemoveAll(dir)
+
+		_, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+		if err == nil || !strings.Contains(err.Error(), "unknown key") {
+			t.Fatalf("Error should be returned for %q: %v", content, err)
+		}
+	}
+}
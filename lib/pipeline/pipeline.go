@@ -1,27 +1,40 @@
 package pipeline
 
 import (
-	"bytes"
 	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
 	"golang.org/x/net/context"
 
 	"github.com/go-yaml/yaml"
+	"github.com/walter-cd/walter/lib/lock"
 	"github.com/walter-cd/walter/lib/notify"
 	"github.com/walter-cd/walter/lib/task"
 )
 
 type Pipeline struct {
-	Build     Build
-	Deploy    Deploy
-	Notifiers []notify.Notifier
+	Build        Build
+	Deploy       Deploy
+	Notifiers    []notify.Notifier
+	Templates    Templates
+	FailFast     *bool  `yaml:"fail_fast"`
+	LockDir      string `yaml:"lock_dir"`
+	OutputMemory int    `yaml:"output_memory"`
+	BaseRef      string `yaml:"base_ref"`
+	Jobs         int    `yaml:"-"`
+	ChangedSince string `yaml:"-"`
+	jobs         semaphore
+	locks        *lock.Manager
+	finished     *finishedTasks
 }
 
 type Build struct {
@@ -36,34 +49,115 @@ type Deploy struct {
 
 type Tasks []*task.Task
 
+// Load loads a pipeline definition. Includes are relative to the working
+// directory.
 func Load(b []byte) (Pipeline, error) {
+	return load(b, "")
+}
+
+// LoadFromFile loads a pipeline definition from file. Includes are relative
+// to the file.
+func LoadFromFile(file string) (Pipeline, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Pipeline{}, err
+	}
+	return load(data, file)
+}
+
+func load(b []byte, file string) (Pipeline, error) {
 	p := Pipeline{}
-	err := yaml.Unmarshal(b, &p)
+	r, err := newRemote(file)
+	if err != nil {
+		return p, err
+	}
+	l := newLoader(Templates{}, r)
+
+	b, err = l.resolveExtends(b, file)
+	if err != nil {
+		return p, err
+	}
+
+	err = yaml.Unmarshal(b, &p)
 	if err == nil {
 		p.Notifiers, err = notify.NewNotifiers(b)
+		if err != nil {
+			return p, err
+		}
+	} else {
+		t := Tasks{}
+		err = yaml.Unmarshal(b, &t)
+		if err != nil {
+			log.Error(err)
+		}
+		p.Build.Tasks = t
+	}
+
+	if err := p.resolveIncludes(l, file); err != nil {
 		return p, err
 	}
+	if err := p.expandTemplates(); err != nil {
+		return p, err
+	}
+	if err := r.save(); err != nil {
+		return p, err
+	}
+	return p, p.Validate()
+}
 
-	t := Tasks{}
-	err = yaml.Unmarshal(b, &t)
-	if err != nil {
-		log.Error(err)
+func (p *Pipeline) resolveIncludes(l *loader, file string) error {
+	var chain []string
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		file, chain = abs, []string{abs}
 	}
 
-	p.Build.Tasks = t
-	return p, nil
+	if p.Templates == nil {
+		p.Templates = Templates{}
+	}
+	l.templates = p.Templates
+	for _, tasks := range []*Tasks{&p.Build.Tasks, &p.Build.Cleanup, &p.Deploy.Tasks, &p.Deploy.Cleanup} {
+		resolved, err := l.resolveIncludes(*tasks, file, chain)
+		if err != nil {
+			return err
+		}
+		*tasks = resolved
+	}
+	return nil
 }
 
-func LoadFromFile(file string) (Pipeline, error) {
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		return Pipeline{}, err
+func (p *Pipeline) expandTemplates() error {
+	for name, t := range p.Templates {
+		if err := t.validate(name); err != nil {
+			return err
+		}
 	}
-	return Load(data)
+
+	for _, tasks := range []*Tasks{&p.Build.Tasks, &p.Build.Cleanup, &p.Deploy.Tasks, &p.Deploy.Cleanup} {
+		expanded, err := expandTemplates(*tasks, p.Templates, nil)
+		if err != nil {
+			return err
+		}
+		*tasks = expanded
+	}
+	return nil
 }
 
 func (p *Pipeline) Run(build, deploy bool) int {
 	failed := false
+	p.finished = newFinishedTasks()
+	defer p.printSummary()
+	p.jobs = newSemaphore(p.Jobs)
+	if p.OutputMemory > 0 {
+		task.OutputMemoryLimit = p.OutputMemory
+	}
+	defer task.RemoveOutputs()
+	task.BaseRef = p.baseRef()
+	defer task.ForgetChanges()
+	p.locks = lock.NewManager(p.lockDir())
 
 	if build {
 		log.Info("Build started")
@@ -120,37 +214,49 @@ func (p *Pipeline) Run(build, deploy bool) int {
 	return 0
 }
 
-func includeTasks(file string) (Tasks, error) {
-	re := regexp.MustCompile(`\$[A-Z1-9\-_]+`)
-	matches := re.FindAllString(file, -1)
-	for _, m := range matches {
-		env := os.Getenv(strings.TrimPrefix(m, "$"))
-		file = strings.Replace(file, m, env, -1)
+func (p *Pipeline) lockDir() string {
+	if p.LockDir == "" {
+		return lock.DefaultDir()
 	}
+	return expandEnv(p.LockDir)
+}
 
-	data, err := ioutil.ReadFile(file)
-	tasks := Tasks{}
-	if err != nil {
-		return tasks, err
+// baseRef returns the git ref which changes of tasks are compared with.
+// -changed-since takes precedence over $WALTER_BASE_REF, which takes
+// precedence over base_ref.
+func (p *Pipeline) baseRef() string {
+	if p.ChangedSince != "" {
+		return p.ChangedSince
 	}
-
-	err = yaml.Unmarshal(data, &tasks)
-	if err != nil {
-		return tasks, err
+	if ref := os.Getenv("WALTER_BASE_REF"); ref != "" {
+		return ref
 	}
+	return expandEnv(p.BaseRef)
+}
 
-	return tasks, err
+func expandEnv(s string) string {
+	re := regexp.MustCompile(`\$[A-Z1-9\-_]+`)
+	for _, m := range re.FindAllString(s, -1) {
+		s = strings.Replace(s, m, os.Getenv(strings.TrimPrefix(m, "$")), -1)
+	}
+	return s
 }
 
 func (p *Pipeline) runTasks(ctx context.Context, cancel context.CancelFunc, tasks Tasks, prevTask *task.Task) error {
+	// Nested calls run after the outermost one, so this is not racy
+	if p.finished == nil {
+		p.finished = newFinishedTasks()
+	}
+
 	failed := false
-	for i, t := range tasks {
+	for i := 0; i < len(tasks); i++ {
+		t := tasks[i]
 		if i > 0 {
 			prevTask = tasks[i-1]
 		}
 
 		if t.Include != "" {
-			include, err := includeTasks(t.Include)
+			include, err := newLoader(Templates{}, nil).includeTasks(t.Include, "", nil)
 			if err != nil {
 				log.Error(err)
 				return err
@@ -177,12 +283,29 @@ func (p *Pipeline) runTasks(ctx context.Context, cancel context.CancelFunc, task
 
 		if failed || (i > 0 && tasks[i-1].Status == task.Failed) {
 			t.Status = task.Skipped
+			t.Detail = "previous task failed"
+			p.finished.add(t)
 			failed = true
 			log.Warnf("[%s] Task skipped because previous task failed", t.Name)
 			continue
 		}
 
-		err := t.Run(ctx, cancel, prevTask)
+		if next := streamTo(t, tasks[i+1:]); next != nil {
+			err := p.runStream(ctx, cancel, t, next, prevTask)
+			if err != nil {
+				failed = true
+				log.Errorf("[%s] %s", t.Name, err)
+			}
+
+			for _, n := range p.Notifiers {
+				n.Notify(t)
+				n.Notify(next)
+			}
+			i++
+			continue
+		}
+
+		err := p.runTask(ctx, cancel, t, prevTask)
 		if err != nil {
 			failed = true
 			log.Errorf("[%s] %s", t.Name, err)
@@ -201,11 +324,20 @@ func (p *Pipeline) runTasks(ctx context.Context, cancel context.CancelFunc, task
 }
 
 func (p *Pipeline) runParallel(ctx context.Context, cancel context.CancelFunc, t *task.Task, prevTask *task.Task) error {
+	defer p.finished.add(t)
+
+	t.Lookup = p.finished.get
+	if skip, err := t.Skip(); err != nil || skip {
+		if err != nil {
+			cancel()
+		}
+		return err
+	}
 
 	var tasks Tasks
 	for _, child := range t.Parallel {
 		if child.Include != "" {
-			include, err := includeTasks(child.Include)
+			include, err := newLoader(Templates{}, nil).includeTasks(child.Include, "", nil)
 			if err != nil {
 				log.Error(err)
 				return err
@@ -216,20 +348,50 @@ func (p *Pipeline) runParallel(ctx context.Context, cancel context.CancelFunc, t
 		}
 	}
 
+	// Includes left to run time, e.g. in pipelines not loaded from a
+	// definition, are not checked when the pipeline is loaded
+	if err := validateTasks(tasks, true); err != nil {
+		log.Error(err)
+		t.Status = task.Failed
+		return err
+	}
+
 	log.Infof("[%s] Start task", t.Name)
 
+	start := time.Now()
+	failFast := p.failFast(t)
+	slots := newSemaphore(t.MaxParallel)
+
 	var wg sync.WaitGroup
 	for _, t := range tasks {
+		if err := slots.acquire(ctx, t); err != nil {
+			t.Status = task.Aborted
+			log.Warnf("[%s] aborted while waiting", t.Name)
+			continue
+		}
+
 		wg.Add(1)
 		go func(t *task.Task) {
 			defer wg.Done()
+			defer slots.release()
+
+			ctx, cancel := ctx, cancel
+			if !failFast {
+				ctx, cancel = context.WithCancel(ctx)
+				defer cancel()
+			}
+
+			if len(t.Parallel) > 0 {
+				p.runParallel(ctx, cancel, t, prevTask)
+				return
+			}
 
 			if len(t.Serial) > 0 {
 				p.runSerial(ctx, cancel, t, prevTask)
 				return
 			}
 
-			t.Run(ctx, cancel, prevTask)
+			p.runTask(ctx, cancel, t, prevTask)
 
 			for _, n := range p.Notifiers {
 				n.Notify(t)
@@ -239,21 +401,26 @@ func (p *Pipeline) runParallel(ctx context.Context, cancel context.CancelFunc, t
 	wg.Wait()
 
 	t.Status = task.Succeeded
+	t.Usage = task.Usage{WallTime: time.Since(start)}
 
-	t.Stdout = new(bytes.Buffer)
-	t.Stderr = new(bytes.Buffer)
-	t.CombinedOutput = new(bytes.Buffer)
+	t.Stdout = task.NewOutput()
+	t.Stderr = task.NewOutput()
+	t.CombinedOutput = task.NewOutput()
 
 	for _, child := range tasks {
-		t.Stdout.Write(child.Stdout.Bytes())
-		t.Stderr.Write(child.Stderr.Bytes())
-		t.CombinedOutput.Write(child.CombinedOutput.Bytes())
-		if child.Status == task.Failed {
+		if child.Status == task.Failed || child.Status == task.Stalled {
 			t.Status = task.Failed
 		}
+		t.Usage.Add(child.Usage)
+		t.Stdout.Append(child.Stdout)
+		t.Stderr.Append(child.Stderr)
+		t.CombinedOutput.Append(child.CombinedOutput)
 	}
 
 	if t.Status == task.Failed {
+		if !failFast {
+			cancel()
+		}
 		return errors.New("One of parallel tasks failed")
 	} else {
 		log.Infof("[%s] End task", t.Name)
@@ -261,11 +428,175 @@ func (p *Pipeline) runParallel(ctx context.Context, cancel context.CancelFunc, t
 	}
 }
 
+// runTask runs a single task once its locks are acquired and one of the
+// global job slots is free.
+func (p *Pipeline) runTask(ctx context.Context, cancel context.CancelFunc, t *task.Task, prevTask *task.Task) error {
+	release, err := p.acquire(ctx, cancel, t)
+	if err == errAborted {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer p.finished.add(t)
+
+	stdin, err := p.stdin(t, prevTask)
+	if err != nil {
+		t.Status = task.Failed
+		cancel()
+		return err
+	}
+	if c, ok := stdin.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	t.Lookup = p.finished.get
+	return t.Run(ctx, cancel, stdin)
+}
+
+// runStream runs producer and consumer connected by a pipe. The pair shares
+// one job slot so that it cannot deadlock waiting for a second one.
+func (p *Pipeline) runStream(ctx context.Context, cancel context.CancelFunc, producer, consumer *task.Task, prevTask *task.Task) error {
+	release, err := p.acquire(ctx, cancel, producer, consumer)
+	if err == errAborted {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer p.finished.add(consumer)
+	defer p.finished.add(producer)
+
+	stdin, err := p.stdin(producer, prevTask)
+	if err != nil {
+		producer.Status = task.Failed
+		cancel()
+		return err
+	}
+	if c, ok := stdin.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	producer.Lookup = p.finished.get
+	consumer.Lookup = p.finished.get
+	return task.RunStream(ctx, cancel, producer, consumer, stdin)
+}
+
+// streamTo returns the task to which stdout of t is streamed, or nil when
+// stdout of t is handed off after it finishes.
+func streamTo(t *task.Task, rest Tasks) *task.Task {
+	switch t.PipeToNext {
+	case "", "buffer":
+		return nil
+	case "stream":
+	default:
+		log.Warnf("[%s] pipe_to_next does not support %s", t.Name, t.PipeToNext)
+		return nil
+	}
+
+	if len(rest) == 0 {
+		return nil
+	}
+
+	next := rest[0]
+	if next.Command == "" || next.Include != "" || len(next.Parallel) > 0 || len(next.Serial) > 0 {
+		log.Warnf("[%s] pipe_to_next: stream is only supported between command tasks", t.Name)
+		return nil
+	}
+	if next.Stdin != nil {
+		log.Warnf("[%s] pipe_to_next: stream cannot be used with stdin of %s", t.Name, next.Name)
+		return nil
+	}
+	return next
+}
+
+var errAborted = errors.New("aborted while waiting")
+
+// acquire waits for the locks of the tasks and a global job slot. The tasks
+// are marked as aborted or failed when they cannot be acquired.
+func (p *Pipeline) acquire(ctx context.Context, cancel context.CancelFunc, tasks ...*task.Task) (func(), error) {
+	t := tasks[0]
+
+	var locks []string
+	var lockTimeout float64
+	seen := map[string]bool{}
+	for _, t := range tasks {
+		for _, l := range t.Locks {
+			if !seen[l] {
+				seen[l] = true
+				locks = append(locks, l)
+			}
+		}
+		if t.LockTimeout > lockTimeout {
+			lockTimeout = t.LockTimeout
+		}
+	}
+
+	setStatus := func(status int) {
+		for _, t := range tasks {
+			t.Status = status
+		}
+	}
+
+	releaseLocks := func() {}
+	if len(locks) > 0 {
+		timeout := time.Duration(lockTimeout * float64(time.Second))
+		release, err := p.locks.Acquire(ctx, t.Name, locks, timeout)
+		if err == context.Canceled {
+			setStatus(task.Aborted)
+			log.Warnf("[%s] %s", t.Name, errAborted)
+			return nil, errAborted
+		}
+		if err != nil {
+			setStatus(task.Failed)
+			cancel()
+			return nil, err
+		}
+		releaseLocks = release
+	}
+
+	if err := p.jobs.acquire(ctx, t); err != nil {
+		releaseLocks()
+		setStatus(task.Aborted)
+		log.Warnf("[%s] %s", t.Name, errAborted)
+		return nil, errAborted
+	}
+
+	return func() {
+		p.jobs.release()
+		releaseLocks()
+	}, nil
+}
+
+// failFast reports whether a failing child of the parallel task t aborts its
+// siblings. It defaults to true.
+func (p *Pipeline) failFast(t *task.Task) bool {
+	if t.FailFast != nil {
+		return *t.FailFast
+	}
+	if p.FailFast != nil {
+		return *p.FailFast
+	}
+	return true
+}
+
 func (p *Pipeline) runSerial(ctx context.Context, cancel context.CancelFunc, t *task.Task, prevTask *task.Task) error {
+	defer p.finished.add(t)
+
+	t.Lookup = p.finished.get
+	if skip, err := t.Skip(); err != nil || skip {
+		if err != nil {
+			cancel()
+		}
+		return err
+	}
+
 	var tasks Tasks
 	for _, child := range t.Serial {
 		if child.Include != "" {
-			include, err := includeTasks(child.Include)
+			include, err := newLoader(Templates{}, nil).includeTasks(child.Include, "", nil)
 			if err != nil {
 				log.Error(err)
 			}
@@ -277,22 +608,25 @@ func (p *Pipeline) runSerial(ctx context.Context, cancel context.CancelFunc, t *
 
 	log.Infof("[%s] Start task", t.Name)
 
+	start := time.Now()
 	p.runTasks(ctx, cancel, tasks, prevTask)
 	t.Status = task.Succeeded
+	t.Usage = task.Usage{WallTime: time.Since(start)}
 	for _, child := range tasks {
-		if child.Status == task.Failed {
+		if child.Status == task.Failed || child.Status == task.Stalled {
 			t.Status = task.Failed
 		}
+		t.Usage.Add(child.Usage)
 	}
 
-	t.Stdout = new(bytes.Buffer)
-	t.Stderr = new(bytes.Buffer)
-	t.CombinedOutput = new(bytes.Buffer)
+	t.Stdout = task.NewOutput()
+	t.Stderr = task.NewOutput()
+	t.CombinedOutput = task.NewOutput()
 
 	lastTask := tasks[len(tasks)-1]
-	t.Stdout.Write(lastTask.Stdout.Bytes())
-	t.Stderr.Write(lastTask.Stderr.Bytes())
-	t.CombinedOutput.Write(lastTask.CombinedOutput.Bytes())
+	t.Stdout.Append(lastTask.Stdout)
+	t.Stderr.Append(lastTask.Stderr)
+	t.CombinedOutput.Append(lastTask.CombinedOutput)
 
 	if t.Status == task.Failed {
 		return errors.New("One of serial tasks failed")
@@ -2,8 +2,12 @@ package pipeline
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -290,6 +294,39 @@ func TestExitStatusSerial(t *testing.T) {
 	}
 }
 
+func TestStatusOfTaskSkippedAfterFailure(t *testing.T) {
+	p := &Pipeline{}
+	t1 := &task.Task{Name: "t1", Command: "exit 1"}
+	t2 := &task.Task{Name: "t2", Command: "echo t2"}
+	c1 := &task.Task{Name: "c1", Command: "echo c1", OnlyIf: `tasks.t2.status == "skipped"`}
+	p.Build.Tasks = Tasks{t1, t2}
+	p.Build.Cleanup = Tasks{c1}
+	p.Run(true, false)
+
+	if t2.Status != task.Skipped || t2.Detail != "previous task failed" {
+		t.Fatalf("t2 should be skipped because t1 failed: %s, %q", t2.StatusName(), t2.Detail)
+	}
+	if c1.Status != task.Succeeded {
+		t.Fatalf("c1 should see that t2 was skipped: %s, %q", c1.StatusName(), c1.Detail)
+	}
+}
+
+func TestInvalidConditionOnBlockCancels(t *testing.T) {
+	for _, b := range []*task.Task{
+		{Name: "serial", OnlyIf: `tasks.none.foo == "bar"`, Serial: Tasks{{Name: "s1", Command: "echo s1"}}},
+		{Name: "parallel", OnlyIf: `tasks.none.foo == "bar"`, Parallel: Tasks{{Name: "p1", Command: "echo p1"}}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		p := &Pipeline{}
+		if err := p.runTasks(ctx, cancel, Tasks{b}, nil); err == nil {
+			t.Fatalf("%s should fail", b.Name)
+		}
+		if ctx.Err() == nil {
+			t.Fatalf("%s should cancel the pipeline", b.Name)
+		}
+	}
+}
+
 func TestIncludeInParallel(t *testing.T) {
 	tsk := &task.Task{
 		Name:     "test include files in parallel task",
@@ -304,3 +341,410 @@ func TestIncludeInParallel(t *testing.T) {
 		t.Fatalf("Exit code should be 1, not %d", code)
 	}
 }
+
+func TestParallelTasksWithoutFailFast(t *testing.T) {
+	p1 := &task.Task{Name: "p1", Command: "sleep 1"}
+	p2 := &task.Task{Name: "p2", Command: "p2p2p2p2"}
+	p3 := &task.Task{Name: "p3", Command: "sleep 1"}
+
+	failFast := false
+	t1 := &task.Task{Name: "foo", Parallel: Tasks{p1, p2, p3}, FailFast: &failFast}
+	t2 := &task.Task{Name: "bar", Command: "echo bar"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{}
+	p.runTasks(ctx, cancel, Tasks{t1, t2}, nil)
+
+	if p1.Status != task.Succeeded {
+		t.Fatal("p1 should have succeeded")
+	}
+
+	if p2.Status != task.Failed {
+		t.Fatal("p2 should have failed")
+	}
+
+	if p3.Status != task.Succeeded {
+		t.Fatal("p3 should have succeeded")
+	}
+
+	if t1.Status != task.Failed {
+		t.Fatal("t1 should have failed")
+	}
+
+	if t2.Status != task.Skipped {
+		t.Fatal("t2 should have been skipped")
+	}
+}
+
+func TestLoadFailFast(t *testing.T) {
+	yaml := `
+fail_fast: false
+build:
+  tasks:
+    - name: parallel
+      fail_fast: true
+      parallel:
+        - name: p1
+          command: echo p1
+`
+	p, err := Load([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.failFast(&task.Task{}) {
+		t.Fatal("fail_fast of the pipeline should be false")
+	}
+
+	if !p.failFast(p.Build.Tasks[0]) {
+		t.Fatal("fail_fast of the parallel task should be true")
+	}
+}
+
+func TestMaxParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := fmt.Sprintf("mkdir %s/lock && sleep 0.2 && rmdir %s/lock", dir, dir)
+	p1 := &task.Task{Name: "p1", Command: command}
+	p2 := &task.Task{Name: "p2", Command: command}
+	p3 := &task.Task{Name: "p3", Command: command}
+
+	t1 := &task.Task{Name: "parallel", Parallel: Tasks{p1, p2, p3}, MaxParallel: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{}
+	p.runTasks(ctx, cancel, Tasks{t1}, nil)
+
+	if t1.Status != task.Succeeded {
+		t.Fatal("parallel tasks should not have run at the same time")
+	}
+}
+
+func TestJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := fmt.Sprintf("mkdir %s/lock && sleep 0.2 && rmdir %s/lock", dir, dir)
+	p1 := &task.Task{Name: "p1", Command: command}
+	p2 := &task.Task{Name: "p2", Command: command}
+	p3 := &task.Task{Name: "p3", Command: command}
+	p4 := &task.Task{Name: "p4", Command: command}
+
+	p5 := &task.Task{Name: "p5", Command: command}
+
+	t1 := &task.Task{Name: "nested", Parallel: Tasks{p3, p4}}
+	t2 := &task.Task{Name: "serial", Serial: Tasks{p5}}
+
+	p := &Pipeline{Jobs: 1}
+	p.Build.Tasks = Tasks{&task.Task{Name: "parallel", Parallel: Tasks{p1, p2, t1, t2}}}
+
+	code := p.Run(true, false)
+	if code != 0 {
+		t.Fatal("tasks should not have run at the same time")
+	}
+
+	for _, tsk := range []*task.Task{p1, p2, p3, p4, p5, t1, t2} {
+		if tsk.Status != task.Succeeded {
+			t.Fatalf("%s should have succeeded, not %s", tsk.Name, tsk.StatusName())
+		}
+	}
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	s := newSemaphore(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.acquire(ctx, &task.Task{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := s.acquire(ctx, &task.Task{}); err == nil {
+		t.Fatal("acquire should fail after the context is cancelled")
+	}
+}
+
+func TestLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := fmt.Sprintf("mkdir %s/lock && sleep 0.2 && rmdir %s/lock", dir, dir)
+	p1 := &task.Task{Name: "p1", Command: command, Locks: []string{"db"}}
+	p2 := &task.Task{Name: "p2", Command: command, Locks: []string{"db"}}
+
+	p := &Pipeline{LockDir: dir}
+	p.Build.Tasks = Tasks{&task.Task{Name: "parallel", Parallel: Tasks{p1, p2}}}
+
+	code := p.Run(true, false)
+	if code != 0 {
+		t.Fatal("tasks sharing a lock should not have run at the same time")
+	}
+}
+
+func TestPipeToNextStream(t *testing.T) {
+	t1 := &task.Task{Name: "t1", Command: "echo a; echo b", PipeToNext: "stream"}
+	t2 := &task.Task{Name: "t2", Command: "tr a-z A-Z"}
+	t3 := &task.Task{Name: "t3", Command: "cat"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{}
+	err := p.runTasks(ctx, cancel, Tasks{t1, t2, t3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(t3.Stdout.String(), "A\nB") {
+		t.Fatalf("t3.Stdout should contain A and B, not %s", t3.Stdout)
+	}
+}
+
+func TestPipeToNextStreamSharedLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t1 := &task.Task{Name: "t1", Command: "echo a", PipeToNext: "stream", Locks: []string{"db"}, LockTimeout: 1}
+	t2 := &task.Task{Name: "t2", Command: "cat", Locks: []string{"db"}, LockTimeout: 1}
+
+	p := &Pipeline{LockDir: dir}
+	p.Build.Tasks = Tasks{t1, t2}
+
+	code := p.Run(true, false)
+	if code != 0 {
+		t.Fatal("streaming tasks sharing a lock should have run")
+	}
+	if t2.Stdout.String() != "a\n" {
+		t.Fatalf("t2.Stdout should be a, not %s", t2.Stdout)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	file, err := ioutil.TempFile("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from file")
+	file.Close()
+
+	t1 := &task.Task{Name: "t1", Command: "echo out; echo err 1>&2"}
+	t2 := &task.Task{Name: "t2", Command: "cat", Stdin: &task.Stdin{None: true}}
+	t3 := &task.Task{Name: "t3", Command: "cat", Stdin: &task.Stdin{Task: "t1", Stream: "stderr"}}
+	t4 := &task.Task{Name: "t4", Command: "cat", Stdin: &task.Stdin{File: file.Name()}}
+	t5 := &task.Task{Name: "t5", Command: "cat", Stdin: &task.Stdin{Literal: "literal"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{}
+	err = p.runTasks(ctx, cancel, Tasks{t1, t2, t3, t4, t5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if t2.Stdout.String() != "" {
+		t.Fatalf("t2.Stdout should be empty, not %s", t2.Stdout)
+	}
+
+	if t3.Stdout.String() != "err\n" {
+		t.Fatalf("t3.Stdout should be stderr of t1, not %s", t3.Stdout)
+	}
+
+	if t4.Stdout.String() != "from file" {
+		t.Fatalf("t4.Stdout should be the content of the file, not %s", t4.Stdout)
+	}
+
+	if t5.Stdout.String() != "literal" {
+		t.Fatalf("t5.Stdout should be literal, not %s", t5.Stdout)
+	}
+}
+
+func TestStdinFromUnknownTask(t *testing.T) {
+	t1 := &task.Task{Name: "t1", Command: "cat", Stdin: &task.Stdin{Task: "no_such_task"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{}
+	p.runTasks(ctx, cancel, Tasks{t1}, nil)
+
+	if t1.Status != task.Failed {
+		t.Fatal("t1 should have failed")
+	}
+}
+
+func TestParallelUsage(t *testing.T) {
+	p1 := &task.Task{Name: "p1", Command: "sleep 0.2"}
+	p2 := &task.Task{Name: "p2", Command: "sleep 0.2"}
+	t1 := &task.Task{Name: "parallel", Parallel: Tasks{p1, p2}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{}
+	p.runTasks(ctx, cancel, Tasks{t1}, nil)
+
+	if t1.Usage.WallTime < 200*time.Millisecond {
+		t.Fatalf("t1.Usage.WallTime should be longer than 200ms, not %s", t1.Usage.WallTime)
+	}
+
+	if t1.Usage.UserTime != p1.Usage.UserTime+p2.Usage.UserTime {
+		t.Fatal("t1.Usage.UserTime should be the sum of children")
+	}
+
+	if t1.Usage.MaxRSS < p1.Usage.MaxRSS || t1.Usage.MaxRSS < p2.Usage.MaxRSS {
+		t.Fatal("t1.Usage.MaxRSS should be the maximum of children")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, s := range map[int64]string{512: "512B", 2048: "2.0KiB", 5 * 1024 * 1024: "5.0MiB"} {
+		if formatBytes(n) != s {
+			t.Fatalf("formatBytes(%d) should be %s, not %s", n, s, formatBytes(n))
+		}
+	}
+}
+
+func TestInteractiveInParallel(t *testing.T) {
+	yaml := `
+build:
+  tasks:
+    - name: parallel
+      parallel:
+        - name: p1
+          command: echo p1
+        - name: serial
+          serial:
+            - name: confirm
+              command: read answer
+              interactive: true
+`
+	_, err := Load([]byte(yaml))
+	if err == nil {
+		t.Fatal("Load() should return err")
+	}
+}
+
+func TestInteractiveWithOutput(t *testing.T) {
+	for _, tasks := range []string{`
+    - name: confirm
+      command: read answer
+      interactive: true
+      no_output_timeout: 10
+`, `
+    - name: confirm
+      command: read answer
+      interactive: true
+      stdin: none
+`, `
+    - name: confirm
+      command: read answer
+      interactive: true
+      fail_on: [error]
+`, `
+    - name: confirm
+      command: read answer
+      interactive: true
+      succeed_on: [done]
+`, `
+    - name: confirm
+      command: read answer
+      interactive: true
+      warn_on: [warning]
+`, `
+    - name: confirm
+      command: read answer; echo $answer
+      interactive: true
+      pipe_to_next: stream
+    - name: next
+      command: cat
+`, `
+    - name: previous
+      command: echo yes
+      pipe_to_next: stream
+    - name: confirm
+      command: read answer
+      interactive: true
+`} {
+		if _, err := Load([]byte("build:\n  tasks:" + tasks)); err == nil || !strings.Contains(err.Error(), "interactive") {
+			t.Fatalf("Load() should return err:%s", tasks)
+		}
+	}
+}
+
+func TestBaseRef(t *testing.T) {
+	os.Unsetenv("WALTER_BASE_REF")
+
+	p, err := Load([]byte(`
+base_ref: origin/main
+build:
+  tasks:
+    - name: api
+      command: make test
+      changes:
+        - services/api/**
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Build.Tasks[0].Changes[0] != "services/api/**" {
+		t.Fatalf("changes is not loaded: %#v", p.Build.Tasks[0].Changes)
+	}
+
+	if p.baseRef() != "origin/main" {
+		t.Fatalf("base_ref should be used: %s", p.baseRef())
+	}
+
+	os.Setenv("WALTER_BASE_REF", "origin/develop")
+	defer os.Unsetenv("WALTER_BASE_REF")
+	if p.baseRef() != "origin/develop" {
+		t.Fatalf("WALTER_BASE_REF should take precedence: %s", p.baseRef())
+	}
+
+	p.ChangedSince = "v1.0.0"
+	if p.baseRef() != "v1.0.0" {
+		t.Fatalf("-changed-since should take precedence: %s", p.baseRef())
+	}
+}
+
+func TestChangesOfBlock(t *testing.T) {
+	os.Unsetenv("WALTER_BASE_REF")
+
+	dir, err := ioutil.TempDir("", "walter-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git(t, dir, "init", "-q")
+	os.MkdirAll(filepath.Join(dir, "services", "api"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("\n"), 0644)
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "first")
+	ioutil.WriteFile(filepath.Join(dir, "services", "api", "main.go"), []byte("package main\n"), 0644)
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "second")
+
+	api := &task.Task{Name: "test api", Command: "echo api", Directory: dir}
+	web := &task.Task{Name: "test web", Command: "echo web", Directory: dir}
+	apiBlock := &task.Task{Name: "api", Directory: dir, Changes: []string{"services/api/**"}, Serial: Tasks{api}}
+	webBlock := &task.Task{Name: "web", Directory: dir, Changes: []string{"services/web/**"}, Parallel: Tasks{web}}
+
+	p := &Pipeline{}
+	p.Build.Tasks = Tasks{apiBlock, webBlock}
+	if code := p.Run(true, false); code != 0 {
+		t.Fatalf("Exit code should be 0, not %d", code)
+	}
+
+	if api.Status != task.Succeeded {
+		t.Fatalf("test api should run: %s", api.StatusName())
+	}
+	if webBlock.Status != task.Skipped || web.Status != task.Init {
+		t.Fatalf("web should be skipped: %s, %s", webBlock.StatusName(), web.StatusName())
+	}
+}
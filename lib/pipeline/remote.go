@@ -0,0 +1,384 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-yaml/yaml"
+	"github.com/walter-cd/walter/lib/lock"
+	"github.com/walter-cd/walter/lib/task"
+)
+
+// Offline makes remote includes and extends be read from the cache only.
+var Offline bool
+
+// CacheDir is the directory caching remote includes and extends. When it
+// is empty, $WALTER_CACHE_DIR or $TMPDIR/walter-cache is used.
+var CacheDir string
+
+// LockFileName is the name of the file next to the pipeline definition
+// which records the revisions and checksums of remote includes.
+const LockFileName = "walter.lock"
+
+const fetchTimeout = 30 * time.Second
+
+// isRemote reports whether location is the URL of a remote file:
+//
+//	https://example.com/ci/tasks.yml#sha256=<checksum>
+//	git+https://example.com/ci.git//tasks/build.yml@v1.2.0
+func isRemote(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") ||
+		strings.HasPrefix(location, "git+")
+}
+
+// resolveLocation returns the location of ref, which is relative to from.
+func resolveLocation(ref, from string) (string, error) {
+	switch {
+	case isRemote(ref) || filepath.IsAbs(ref):
+		return ref, nil
+	case strings.HasPrefix(from, "git+"):
+		g, err := parseGitLocation(from)
+		if err != nil {
+			return "", err
+		}
+		g.path = path.Join(path.Dir(g.path), ref)
+		return g.String(), nil
+	case isRemote(from):
+		base, err := url.Parse(from)
+		if err != nil {
+			return "", err
+		}
+		base.Fragment = ""
+		rel, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(rel).String(), nil
+	case from != "":
+		return filepath.Join(filepath.Dir(from), ref), nil
+	}
+	return ref, nil
+}
+
+type gitLocation struct {
+	repo string
+	path string
+	ref  string
+}
+
+// parseGitLocation parses git+<repository>//<path>[@<ref>].
+func parseGitLocation(location string) (*gitLocation, error) {
+	s := strings.TrimPrefix(location, "git+")
+	scheme := strings.Index(s, "://")
+	if scheme < 0 {
+		return nil, fmt.Errorf("%s is not a git URL", location)
+	}
+	sep := strings.Index(s[scheme+3:], "//")
+	if sep < 0 {
+		return nil, fmt.Errorf("%s has no path, e.g. git+https://example.com/ci.git//tasks.yml", location)
+	}
+	sep += scheme + 3
+
+	g := &gitLocation{repo: s[:sep], path: s[sep+2:], ref: "HEAD"}
+	if at := strings.LastIndex(g.path, "@"); at >= 0 {
+		g.path, g.ref = g.path[:at], g.path[at+1:]
+	}
+	return g, nil
+}
+
+func (g *gitLocation) String() string {
+	return "git+" + g.repo + "//" + g.path + "@" + g.ref
+}
+
+type lockEntry struct {
+	Revision string `yaml:"revision,omitempty"`
+	Sha256   string `yaml:"sha256"`
+}
+
+type lockFile struct {
+	Includes map[string]lockEntry `yaml:"includes"`
+}
+
+// remote fetches remote files into the cache and checks them against the
+// lock file.
+type remote struct {
+	file    string
+	lock    lockFile
+	changed bool
+	synced  map[string]bool
+}
+
+// newRemote returns a remote which records fetched files in the lock file
+// next to the pipeline definition file, or nowhere when file is empty.
+func newRemote(file string) (*remote, error) {
+	r := &remote{lock: lockFile{Includes: map[string]lockEntry{}}, synced: map[string]bool{}}
+	if file == "" {
+		return r, nil
+	}
+
+	r.file = filepath.Join(filepath.Dir(file), LockFileName)
+	data, err := ioutil.ReadFile(r.file)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &r.lock); err != nil {
+		return nil, fmt.Errorf("%s: %s", r.file, err)
+	}
+	if r.lock.Includes == nil {
+		r.lock.Includes = map[string]lockEntry{}
+	}
+	return r, nil
+}
+
+// fetch returns the content of the remote file at location. The content
+// must match the checksum in the fragment of location and in the lock file.
+func (r *remote) fetch(location string) ([]byte, error) {
+	key, pin := location, ""
+	if i := strings.Index(location, "#sha256="); i >= 0 {
+		key, pin = location[:i], location[i+len("#sha256="):]
+	}
+	locked, isLocked := r.lock.Includes[key]
+	// A pin other than the locked checksum replaces the entry
+	if !isLocked || (pin != "" && locked.Sha256 != pin) {
+		locked, isLocked = lockEntry{Sha256: pin}, false
+	}
+
+	var data []byte
+	var err error
+	entry := lockEntry{}
+	if strings.HasPrefix(key, "git+") {
+		data, entry.Revision, err = r.fetchGit(key, locked.Revision)
+	} else {
+		data, err = fetchHTTP(key, locked.Sha256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := checksum(data)
+	switch {
+	case pin != "" && sum != pin:
+		return nil, fmt.Errorf("checksum of %s is %s, not %s", key, sum, pin)
+	case isLocked && sum != locked.Sha256:
+		return nil, fmt.Errorf("checksum of %s is %s, not %s recorded in %s", key, sum, locked.Sha256, r.file)
+	}
+
+	if !isLocked {
+		entry.Sha256 = sum
+		r.lock.Includes[key] = entry
+		r.changed = true
+	}
+	return data, nil
+}
+
+// save writes the lock file when new remote files were fetched.
+func (r *remote) save() error {
+	if r.file == "" || !r.changed {
+		return nil
+	}
+
+	data, err := yaml.Marshal(r.lock)
+	if err != nil {
+		return err
+	}
+	r.changed = false
+	return ioutil.WriteFile(r.file, data, 0644)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func cacheDir() string {
+	if CacheDir != "" {
+		return CacheDir
+	}
+	if dir := os.Getenv("WALTER_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "walter-cache")
+}
+
+// cachePath returns the path in the cache for the URL.
+func cachePath(kind, u string) string {
+	return filepath.Join(cacheDir(), kind, checksum([]byte(u)))
+}
+
+// lockCache locks the cache of u against other walter processes. The
+// returned function releases the lock.
+func lockCache(kind, u string) (func(), error) {
+	m := lock.NewManager(filepath.Join(cacheDir(), "locks"))
+	return m.Acquire(context.Background(), "cache", []string{kind + "-" + checksum([]byte(u))}, 0)
+}
+
+// writeFileAtomic writes data to a temporary file renamed to file, so that
+// file is never read half written.
+func writeFileAtomic(file string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// fetchHTTP downloads u, unless the cache already has the content with the
+// checksum sum or walter is offline.
+func fetchHTTP(u, sum string) ([]byte, error) {
+	cache := cachePath("http", u)
+	unlock, err := lockCache("http", u)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	data, err := ioutil.ReadFile(cache)
+	if err == nil && (Offline || (sum != "" && checksum(data) == sum)) {
+		return data, nil
+	}
+	if Offline {
+		return nil, fmt.Errorf("%s is not cached and walter is offline", u)
+	}
+
+	log.Infof("Fetching %s", u)
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch %s: %s", u, resp.Status)
+	}
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cache), 0755); err != nil {
+		return nil, err
+	}
+	return data, writeFileAtomic(cache, data)
+}
+
+// fetchGit reads a file of a git repository mirrored in the cache, at
+// revision or at the ref of location when revision is empty. The mirror is
+// updated at most once. It returns the content and the revision.
+func (r *remote) fetchGit(location, revision string) ([]byte, string, error) {
+	g, err := parseGitLocation(location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	unlock, err := lockCache("git", g.repo)
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+
+	dir := cachePath("git", g.repo)
+	_, err = os.Stat(dir)
+	cached := err == nil
+	switch {
+	case !cached && Offline:
+		return nil, "", fmt.Errorf("%s is not cached and walter is offline", g.repo)
+	case !cached:
+		log.Infof("Cloning %s", g.repo)
+		if err := cloneMirror(g.repo, dir); err != nil {
+			return nil, "", err
+		}
+		r.synced[g.repo] = true
+	case !Offline && !r.synced[g.repo] && (revision == "" || !hasRevision(dir, revision)):
+		log.Infof("Fetching %s", g.repo)
+		if _, err := runGit(dir, "fetch", "--quiet", "--prune"); err != nil {
+			return nil, "", err
+		}
+		r.synced[g.repo] = true
+	}
+
+	if revision == "" {
+		out, err := runGit(dir, "rev-parse", "--verify", g.ref+"^{commit}")
+		if err != nil {
+			return nil, "", err
+		}
+		revision = strings.TrimSpace(string(out))
+	}
+
+	data, err := runGit(dir, "show", revision+":"+g.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, revision, nil
+}
+
+// cloneMirror clones a mirror of repo into a temporary directory renamed
+// to dir, so that an interrupted clone does not leave a broken mirror.
+func cloneMirror(repo, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".clone-")
+	if err != nil {
+		return err
+	}
+	if _, err := runGit("", "clone", "--quiet", "--mirror", repo, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return nil
+}
+
+func hasRevision(dir, revision string) bool {
+	_, err := runGit(dir, "cat-file", "-e", revision+"^{commit}")
+	return err == nil
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	if dir != "" {
+		args = append([]string{"--git-dir", dir}, args...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := task.RunHelper(cmd); err != nil {
+		return nil, fmt.Errorf("git %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
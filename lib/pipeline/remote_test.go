@@ -0,0 +1,261 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	args = append([]string{"-c", "user.name=walter", "-c", "user.email=walter@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %s", args, err, out)
+	}
+}
+
+func buildTaskNames(t *testing.T, file string) string {
+	p, err := LoadFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, task := range p.Build.Tasks {
+		names = append(names, task.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func useCache(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "walter-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	CacheDir = dir
+	return dir
+}
+
+func TestRemoteIncludeHTTP(t *testing.T) {
+	files := map[string]string{
+		"/ci/tasks.yml":  "- name: remote\n  command: echo remote\n- include: common.yml\n",
+		"/ci/common.yml": "- name: common\n  command: echo common\n",
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+
+	cache := useCache(t)
+	defer os.RemoveAll(cache)
+	defer func() { CacheDir, Offline = "", false }()
+
+	dir := writeFiles(t, map[string]string{
+		"pipeline.yml": fmt.Sprintf("build:\n  tasks:\n    - include: %s/ci/tasks.yml\n", ts.URL),
+	})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pipeline.yml")
+
+	if names := buildTaskNames(t, file); names != "remote,common" {
+		t.Fatalf("tasks are %s", names)
+	}
+
+	lock, err := ioutil.ReadFile(filepath.Join(dir, LockFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(lock), ts.URL+"/ci/common.yml") {
+		t.Fatalf("lock file does not record common.yml:\n%s", lock)
+	}
+
+	// Locked files are read from the cache
+	files["/ci/common.yml"] = "- name: changed\n  command: echo changed\n"
+	requests = 0
+	if names := buildTaskNames(t, file); names != "remote,common" {
+		t.Fatalf("tasks are %s", names)
+	}
+	if requests != 0 {
+		t.Fatalf("%d requests for locked files", requests)
+	}
+
+	// Files not matching the lock file are rejected
+	defer os.RemoveAll(useCache(t))
+	_, err = LoadFromFile(file)
+	if err == nil || !strings.Contains(err.Error(), "recorded in") {
+		t.Fatalf("error is %v", err)
+	}
+
+	// Offline uses the cache even without the lock file
+	CacheDir = cache
+	Offline = true
+	ts.Close()
+	os.Remove(filepath.Join(dir, LockFileName))
+	if names := buildTaskNames(t, file); names != "remote,common" {
+		t.Fatalf("tasks are %s", names)
+	}
+
+	os.Remove(filepath.Join(dir, LockFileName))
+	defer os.RemoveAll(useCache(t))
+	_, err = LoadFromFile(file)
+	if err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("error is %v", err)
+	}
+}
+
+func TestRemoteIncludeChecksum(t *testing.T) {
+	content := "- name: remote\n  command: echo remote\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+
+	defer os.RemoveAll(useCache(t))
+	defer func() { CacheDir = "" }()
+
+	yml := "build:\n  tasks:\n    - include: %s/tasks.yml#sha256=%s\n"
+	if _, err := Load([]byte(fmt.Sprintf(yml, ts.URL, checksum([]byte(content))))); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load([]byte(fmt.Sprintf(yml, ts.URL, checksum([]byte("other")))))
+	if err == nil || !strings.Contains(err.Error(), "checksum of") {
+		t.Fatalf("error is %v", err)
+	}
+}
+
+func TestRemoteIncludeChangedPin(t *testing.T) {
+	content := "- name: v1\n  command: echo v1\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+
+	defer os.RemoveAll(useCache(t))
+	defer func() { CacheDir = "" }()
+
+	dir := writeFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pipeline.yml")
+	write := func() {
+		yml := fmt.Sprintf("build:\n  tasks:\n    - include: %s/tasks.yml#sha256=%s\n", ts.URL, checksum([]byte(content)))
+		if err := ioutil.WriteFile(file, []byte(yml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	if names := buildTaskNames(t, file); names != "v1" {
+		t.Fatalf("tasks are %s", names)
+	}
+
+	// A new pin replaces the checksum in the lock file
+	content = "- name: v2\n  command: echo v2\n"
+	write()
+	if names := buildTaskNames(t, file); names != "v2" {
+		t.Fatalf("tasks are %s", names)
+	}
+	lock, err := ioutil.ReadFile(filepath.Join(dir, LockFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(lock), checksum([]byte(content))) {
+		t.Fatalf("lock file does not record the new checksum:\n%s", lock)
+	}
+}
+
+func TestRemoteIncludeGit(t *testing.T) {
+	repo, err := ioutil.TempDir("", "walter-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Join(repo, filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, repo, "init", "-q")
+	write("ci/tasks.yml", "- name: v1\n  command: echo v1\n- include: common.yml\n")
+	write("ci/common.yml", "- name: common\n  command: echo common\n")
+	git(t, repo, "add", "-A")
+	git(t, repo, "commit", "-q", "-m", "first")
+	git(t, repo, "tag", "v1")
+	write("ci/tasks.yml", "- name: v2\n  command: echo v2\n")
+	git(t, repo, "commit", "-q", "-a", "-m", "second")
+
+	defer os.RemoveAll(useCache(t))
+	defer func() { CacheDir = "" }()
+
+	dir := writeFiles(t, map[string]string{
+		"tag.yml":  fmt.Sprintf("- include: git+file://%s//ci/tasks.yml@v1\n", repo),
+		"head.yml": fmt.Sprintf("- include: git+file://%s//ci/tasks.yml\n", repo),
+	})
+	defer os.RemoveAll(dir)
+
+	if names := buildTaskNames(t, filepath.Join(dir, "tag.yml")); names != "v1,common" {
+		t.Fatalf("tasks are %s", names)
+	}
+	if names := buildTaskNames(t, filepath.Join(dir, "head.yml")); names != "v2" {
+		t.Fatalf("tasks are %s", names)
+	}
+
+	// The lock file keeps the revision
+	write("ci/tasks.yml", "- name: v3\n  command: echo v3\n")
+	git(t, repo, "commit", "-q", "-a", "-m", "third")
+	if names := buildTaskNames(t, filepath.Join(dir, "head.yml")); names != "v2" {
+		t.Fatalf("tasks are %s", names)
+	}
+
+	os.Remove(filepath.Join(dir, LockFileName))
+	if names := buildTaskNames(t, filepath.Join(dir, "head.yml")); names != "v3" {
+		t.Fatalf("tasks are %s", names)
+	}
+
+	// A failed clone leaves nothing in the cache
+	missing := filepath.Join(repo, "missing")
+	_, err = Load([]byte(fmt.Sprintf("build:\n  tasks:\n    - include: git+file://%s//tasks.yml\n", missing)))
+	if err == nil {
+		t.Fatal("Error should be returned")
+	}
+	entries, _ := ioutil.ReadDir(filepath.Dir(cachePath("git", "file://"+missing)))
+	for _, e := range entries {
+		if e.Name() == filepath.Base(cachePath("git", "file://"+missing)) || strings.HasPrefix(e.Name(), ".clone-") {
+			t.Fatalf("%s should not be left in the cache", e.Name())
+		}
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	cases := []struct {
+		ref, from, location string
+	}{
+		{"common.yml", "https://example.com/ci/tasks.yml#sha256=abc", "https://example.com/ci/common.yml"},
+		{"../common.yml", "git+https://example.com/ci.git//ci/tasks.yml@v1", "git+https://example.com/ci.git//common.yml@v1"},
+		{"https://example.com/a.yml", "/ci/pipeline.yml", "https://example.com/a.yml"},
+		{"tasks.yml", "/ci/pipeline.yml", "/ci/tasks.yml"},
+	}
+	for _, c := range cases {
+		location, err := resolveLocation(c.ref, c.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location != c.location {
+			t.Fatalf("%s relative to %s is %s, not %s", c.ref, c.from, location, c.location)
+		}
+	}
+}
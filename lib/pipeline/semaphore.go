@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"golang.org/x/net/context"
+
+	"github.com/walter-cd/walter/lib/task"
+)
+
+// semaphore limits the number of tasks running at the same time.
+// A nil semaphore means no limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire blocks until a slot is free or ctx is cancelled.
+func (s semaphore) acquire(ctx context.Context, t *task.Task) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	default:
+	}
+
+	log.Infof("[%s] Waiting for a free slot", t.Name)
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s == nil {
+		return
+	}
+	<-s
+}
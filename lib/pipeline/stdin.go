@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/walter-cd/walter/lib/task"
+)
+
+// finishedTasks holds finished tasks by name so that later tasks can read
+// their output.
+type finishedTasks struct {
+	mu    sync.Mutex
+	tasks map[string]*task.Task
+	order Tasks
+}
+
+func newFinishedTasks() *finishedTasks {
+	return &finishedTasks{tasks: map[string]*task.Task{}}
+}
+
+func (f *finishedTasks) add(t *task.Task) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.order = append(f.order, t)
+	if t.Name != "" {
+		f.tasks[t.Name] = t
+	}
+}
+
+// all returns finished tasks in the order they finished.
+func (f *finishedTasks) all() Tasks {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append(Tasks{}, f.order...)
+}
+
+func (f *finishedTasks) get(name string) *task.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tasks[name]
+}
+
+// stdin resolves the stdin of t. Without stdin definition, t reads stdout of
+// prevTask.
+func (p *Pipeline) stdin(t *task.Task, prevTask *task.Task) (io.Reader, error) {
+	s := t.Stdin
+	if s == nil {
+		if prevTask == nil || prevTask.Stdout == nil {
+			return nil, nil
+		}
+		return prevTask.Stdout.Reader()
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.None:
+		return nil, nil
+	case s.Task != "":
+		src := p.finished.get(s.Task)
+		if src == nil {
+			return nil, fmt.Errorf("stdin: task %s has not finished", s.Task)
+		}
+		out := src.Output(s.Stream)
+		if out == nil {
+			return nil, nil
+		}
+		return out.Reader()
+	case s.File != "":
+		f, err := os.Open(expandEnv(s.File))
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return strings.NewReader(s.Literal), nil
+	}
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/walter-cd/walter/lib/task"
+)
+
+// printSummary logs status and resource usage of the finished tasks.
+func (p *Pipeline) printSummary() {
+	tasks := p.finished.all()
+	if len(tasks) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tSTATUS\tWALL\tUSER\tSYS\tMAX RSS")
+	for _, t := range tasks {
+		if t.Status == task.Init {
+			continue
+		}
+		u := t.Usage
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Name, t.StatusName(),
+			round(u.WallTime), round(u.UserTime), round(u.SysTime), formatBytes(u.MaxRSS))
+	}
+	w.Flush()
+
+	log.Info("Summary")
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		log.Info(line)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d - d%time.Millisecond
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
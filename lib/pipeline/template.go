@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/walter-cd/walter/lib/task"
+)
+
+// Template is a reusable sequence of tasks. Placeholders like {{ image }} in
+// the tasks are replaced with the parameters given by tasks using the
+// template.
+//
+//	templates:
+//	  docker:
+//	    params:
+//	      image:
+//	      tag: {default: latest}
+//	    tasks:
+//	      - name: build {{ image }}
+//	        command: docker build -t {{ image }}:{{ tag }} .
+type Template struct {
+	Params map[string]*Param
+	Tasks  []interface{}
+	file   string
+}
+
+// Param is a parameter of a template. A parameter without default is
+// required.
+type Param struct {
+	Default *string
+}
+
+// Templates holds templates by name.
+type Templates map[string]*Template
+
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+
+// add adds the templates of src, which are defined in file. A file included
+// more than once can add its templates again, but different files cannot
+// define different templates with the same name.
+func (t Templates) add(src Templates, file string) error {
+	for name, tmpl := range src {
+		if tmpl != nil {
+			tmpl.file = file
+		}
+		if defined, ok := t[name]; ok {
+			if defined != nil && tmpl != nil && (defined.file == file || defined.equal(tmpl)) {
+				continue
+			}
+			return fmt.Errorf("template %s is defined more than once%s", name, formatChain([]string{file}, " in "))
+		}
+		t[name] = tmpl
+	}
+	return nil
+}
+
+func (t *Template) equal(o *Template) bool {
+	return reflect.DeepEqual(t.Params, o.Params) && reflect.DeepEqual(t.Tasks, o.Tasks)
+}
+
+// validate checks that the placeholders of the template are declared
+// parameters.
+func (t *Template) validate(name string) error {
+	if t == nil || len(t.Tasks) == 0 {
+		return fmt.Errorf("template %s has no tasks", name)
+	}
+
+	var err error
+	walkStrings(t.Tasks, func(s string) string {
+		for _, m := range placeholder.FindAllStringSubmatch(s, -1) {
+			if _, ok := t.Params[m[1]]; !ok && err == nil {
+				err = fmt.Errorf("template %s: %s is not a parameter", name, m[1])
+			}
+		}
+		return s
+	})
+	return err
+}
+
+// expand returns the tasks of the template with the parameters.
+func (t *Template) expand(name string, with map[string]string) (Tasks, error) {
+	values := map[string]string{}
+	for k, v := range with {
+		if _, ok := t.Params[k]; !ok {
+			return nil, fmt.Errorf("template %s has no parameter %s", name, k)
+		}
+		values[k] = v
+	}
+
+	var missing []string
+	for k, p := range t.Params {
+		if _, ok := values[k]; ok {
+			continue
+		}
+		if p == nil || p.Default == nil {
+			missing = append(missing, k)
+			continue
+		}
+		values[k] = *p.Default
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("template %s requires %s", name, strings.Join(missing, ", "))
+	}
+
+	raw := walkStrings(t.Tasks, func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+			return values[placeholder.FindStringSubmatch(m)[1]]
+		})
+	})
+
+	// Tasks are decoded again so that they are fresh copies with the
+	// same definitions as tasks written without templates
+	b, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	tasks := Tasks{}
+	if err := yaml.Unmarshal(b, &tasks); err != nil {
+		return nil, fmt.Errorf("template %s: %s", name, err)
+	}
+	return tasks, nil
+}
+
+// otherKeys returns the keys of t set besides name, use and with, which
+// would be lost when the task is replaced with the tasks of a template.
+func otherKeys(t *task.Task) []string {
+	v := reflect.ValueOf(*t)
+	var keys []string
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		if f.PkgPath != "" || key == "-" || key == "name" || key == "use" || key == "with" {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), reflect.Zero(f.Type).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// walkStrings returns a copy of v whose strings are replaced by f.
+func walkStrings(v interface{}, f func(string) string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return f(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = walkStrings(e, f)
+		}
+		return c
+	case map[interface{}]interface{}:
+		c := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			c[k] = walkStrings(e, f)
+		}
+		return c
+	}
+	return v
+}
+
+// expandTemplates replaces tasks using templates, also in parallel and
+// serial tasks, with the tasks of the templates. A task using a template
+// with a name runs the tasks of the template as its serial tasks. chain is
+// the list of templates being expanded.
+func expandTemplates(tasks Tasks, templates Templates, chain []string) (Tasks, error) {
+	var expanded Tasks
+	for _, t := range tasks {
+		if t.Use == "" {
+			if t.With != nil {
+				return nil, fmt.Errorf("[%s] cannot use with without use", t.Name)
+			}
+
+			var err error
+			if t.Parallel, err = expandTemplates(t.Parallel, templates, chain); err != nil {
+				return nil, err
+			}
+			if t.Serial, err = expandTemplates(t.Serial, templates, chain); err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, t)
+			continue
+		}
+
+		use, err := expandTemplate(t, templates, chain)
+		if err != nil {
+			if t.Name != "" {
+				return nil, fmt.Errorf("[%s] %s", t.Name, err)
+			}
+			return nil, err
+		}
+
+		if t.Name != "" {
+			expanded = append(expanded, &task.Task{Name: t.Name, Serial: use})
+		} else {
+			expanded = append(expanded, use...)
+		}
+	}
+	return expanded, nil
+}
+
+func expandTemplate(t *task.Task, templates Templates, chain []string) (Tasks, error) {
+	tmpl, ok := templates[t.Use]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("template %s is not defined", t.Use)
+	case includes(chain, t.Use):
+		return nil, fmt.Errorf("template cycle: %s -> %s", strings.Join(chain, " -> "), t.Use)
+	}
+	if keys := otherKeys(t); len(keys) > 0 {
+		return nil, fmt.Errorf("cannot use use with %s, only with name and with", strings.Join(keys, ", "))
+	}
+
+	tasks, err := tmpl.expand(t.Use, t.With)
+	if err != nil {
+		return nil, err
+	}
+	return expandTemplates(tasks, templates, append(append([]string{}, chain...), t.Use))
+}
@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const dockerTemplate = `
+templates:
+  docker:
+    params:
+      image:
+      tag:
+        default: latest
+    tasks:
+      - name: build {{ image }}
+        command: docker build -t {{ image }}:{{tag}} .
+      - name: push {{ image }}
+        command: docker push {{ image }}:{{ tag }}
+        wait_for:
+          file: "{{ image }}.built"
+          state: present
+`
+
+func TestTemplates(t *testing.T) {
+	p, err := Load([]byte(dockerTemplate + `
+build:
+  tasks:
+    - use: docker
+      with:
+        image: api
+    - name: web
+      use: docker
+      with:
+        image: web
+        tag: 1.0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := p.Build.Tasks
+	if len(tasks) != 3 {
+		t.Fatalf("Templates are not expanded: %d tasks", len(tasks))
+	}
+	if tasks[0].Name != "build api" || tasks[0].Command != "docker build -t api:latest ." {
+		t.Fatalf("Parameters are not replaced: %#v", tasks[0])
+	}
+	if tasks[1].WaitFor == nil || tasks[1].WaitFor.File != "api.built" {
+		t.Fatalf("Parameters are not replaced in wait_for: %#v", tasks[1].WaitFor)
+	}
+	if tasks[2].Name != "web" || len(tasks[2].Serial) != 2 || tasks[2].Serial[1].Command != "docker push web:1.0" {
+		t.Fatalf("Named use should run the template as serial tasks: %#v", tasks[2])
+	}
+}
+
+func TestTemplateParameters(t *testing.T) {
+	cases := map[string]string{
+		"- use: docker\n": "template docker requires image",
+		"- use: docker\n  with: {image: a, tags: b}\n": "template docker has no parameter tags",
+		"- use: kubernetes\n":                          "template kubernetes is not defined",
+		"- use: docker\n  command: echo\n":             "cannot use use with command",
+		"- use: docker\n  only_if: x\n  locks: [a]\n":  "cannot use use with only_if, locks",
+	}
+	for tasks, expected := range cases {
+		_, err := Load([]byte(dockerTemplate + "build:\n  tasks:\n" + indent(tasks)))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Error should contain %q: %v", expected, err)
+		}
+	}
+
+	_, err := Load([]byte(`
+templates:
+  broken:
+    params:
+      image:
+    tasks:
+      - command: docker build -t {{ image }}:{{ tag }} .
+`))
+	if err == nil || !strings.Contains(err.Error(), "tag is not a parameter") {
+		t.Fatalf("Error should be returned: %v", err)
+	}
+}
+
+func TestTemplatesInInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pipeline.yml": `
+build:
+  tasks:
+    - include: ci/templates.yml
+    - use: docker
+      with:
+        image: api
+`,
+		"ci/templates.yml": dockerTemplate + `
+tasks:
+  - name: login
+    command: docker login
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, task := range p.Build.Tasks {
+		names = append(names, task.Name)
+	}
+	if strings.Join(names, ",") != "login,build api,push api" {
+		t.Fatalf("Templates in included files are not expanded: %v", names)
+	}
+}
+
+func TestTemplatesIncludedTwice(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pipeline.yml": `
+build:
+  tasks:
+    - include: ci/templates.yml
+    - use: docker
+      with:
+        image: api
+deploy:
+  tasks:
+    - include: ci/templates.yml
+    - include: ci/other.yml
+`,
+		"ci/templates.yml": dockerTemplate,
+		"ci/other.yml":     dockerTemplate,
+		"ci/conflict.yml": `
+templates:
+  docker:
+    tasks:
+      - command: docker build .
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	if _, err := LoadFromFile(filepath.Join(dir, "pipeline.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "pipeline.yml"), []byte(`
+build:
+  tasks:
+    - include: ci/templates.yml
+    - include: ci/conflict.yml
+`), 0644)
+	_, err := LoadFromFile(filepath.Join(dir, "pipeline.yml"))
+	if err == nil || !strings.Contains(err.Error(), "template docker is defined more than once") {
+		t.Fatalf("Error should be returned: %v", err)
+	}
+}
+
+func indent(s string) string {
+	return "    " + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n    ", -1) + "\n"
+}
+
+func TestTemplateCycle(t *testing.T) {
+	_, err := Load([]byte(`
+templates:
+  a:
+    tasks:
+      - use: b
+  b:
+    tasks:
+      - use: a
+build:
+  tasks:
+    - use: a
+`))
+	if err == nil || !strings.Contains(err.Error(), "template cycle: a -> b -> a") {
+		t.Fatalf("Error should be returned: %v", err)
+	}
+}
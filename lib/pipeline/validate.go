@@ -0,0 +1,47 @@
+package pipeline
+
+import "fmt"
+
+// Validate checks the task definitions of the pipeline.
+func (p *Pipeline) Validate() error {
+	for _, tasks := range []Tasks{p.Build.Tasks, p.Build.Cleanup, p.Deploy.Tasks, p.Deploy.Cleanup} {
+		if err := validateTasks(tasks, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTasks(tasks Tasks, inParallel bool) error {
+	for i, t := range tasks {
+		piped := i > 0 && tasks[i-1].PipeToNext == "stream"
+		switch {
+		case t.Interactive && inParallel:
+			return fmt.Errorf("[%s] interactive tasks cannot run in parallel", t.Name)
+		case t.Interactive && t.TTY:
+			return fmt.Errorf("[%s] cannot use interactive and tty at the same time", t.Name)
+		case t.Interactive && t.Stdin != nil:
+			return fmt.Errorf("[%s] cannot use interactive and stdin at the same time", t.Name)
+		case t.Interactive && len(t.FailOn) > 0:
+			return fmt.Errorf("[%s] cannot use interactive and fail_on at the same time", t.Name)
+		case t.Interactive && len(t.SucceedOn) > 0:
+			return fmt.Errorf("[%s] cannot use interactive and succeed_on at the same time", t.Name)
+		case t.Interactive && len(t.WarnOn) > 0:
+			return fmt.Errorf("[%s] cannot use interactive and warn_on at the same time", t.Name)
+		case t.Interactive && t.NoOutputTimeout > 0:
+			return fmt.Errorf("[%s] cannot use interactive and no_output_timeout at the same time", t.Name)
+		case t.Interactive && t.PipeToNext == "stream":
+			return fmt.Errorf("[%s] interactive tasks cannot stream stdout to the next task", t.Name)
+		case t.Interactive && piped:
+			return fmt.Errorf("[%s] interactive tasks cannot read stdout streamed from %s", t.Name, tasks[i-1].Name)
+		}
+
+		if err := validateTasks(t.Parallel, true); err != nil {
+			return err
+		}
+		if err := validateTasks(t.Serial, inParallel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package task
+
+import "regexp"
+
+var ansi = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripANSI removes ANSI escape sequences like colors from s.
+func StripANSI(s string) string {
+	return ansi.ReplaceAllString(s, "")
+}
@@ -0,0 +1,10 @@
+package task
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	s := StripANSI("\x1b[1;31mred\x1b[0m \x1b]0;title\x07plain")
+	if s != "red plain" {
+		t.Fatalf("ANSI escape sequences should be removed, not %q", s)
+	}
+}
@@ -0,0 +1,130 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// BaseRef is the git ref which changes are compared with. When it is empty,
+// $WALTER_BASE_REF or the parent of HEAD is used.
+var BaseRef string
+
+// changed reports whether files matching the globs of changes have changed.
+// The task runs when the changes cannot be determined.
+func (t *Task) changed() bool {
+	files, err := changedFiles(t.Directory)
+	if err != nil {
+		log.Warnf("[%s] Running regardless of changes: %s", t.Name, err)
+		return true
+	}
+	return matchGlobs(t.Changes, files)
+}
+
+type changes struct {
+	files []string
+	err   error
+}
+
+// changesCache holds the changed files by base ref and directory, so that
+// git runs once for all the tasks of a run.
+var changesCache = struct {
+	mu    sync.Mutex
+	files map[string]changes
+}{files: map[string]changes{}}
+
+// ForgetChanges clears the changed files cached during a run.
+func ForgetChanges() {
+	changesCache.mu.Lock()
+	defer changesCache.mu.Unlock()
+	changesCache.files = map[string]changes{}
+}
+
+// changedFiles returns the files changed on HEAD since it diverged from
+// BaseRef. The paths are relative to the root of the repository.
+func changedFiles(dir string) ([]string, error) {
+	base := BaseRef
+	if base == "" {
+		base = os.Getenv("WALTER_BASE_REF")
+	}
+	if base == "" {
+		base = "HEAD^"
+	}
+
+	changesCache.mu.Lock()
+	defer changesCache.mu.Unlock()
+
+	key := base + "\x00" + dir
+	c, ok := changesCache.files[key]
+	if !ok {
+		c.files, c.err = gitChangedFiles(base, dir)
+		changesCache.files[key] = c
+	}
+	return c.files, c.err
+}
+
+func gitChangedFiles(base, dir string) ([]string, error) {
+	// base...HEAD compares HEAD with the merge base, so that changes made on
+	// base since HEAD diverged from it are left out
+	var out bytes.Buffer
+	cmd := exec.Command("git", "diff", "-z", "--name-only", base+"...HEAD")
+	cmd.Dir = dir
+	cmd.Stdout = &out
+	if err := RunHelper(cmd); err != nil {
+		return nil, fmt.Errorf("cannot get files changed since %s: %s", base, err)
+	}
+
+	// Paths are separated by NUL and not quoted
+	var files []string
+	for _, f := range strings.Split(out.String(), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// matchGlobs reports whether one of the files matches one of the glob
+// patterns.
+func matchGlobs(patterns, files []string) bool {
+	for _, p := range patterns {
+		re := globRegexp(p)
+		for _, f := range files {
+			if re.MatchString(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globRegexp converts a glob pattern to a regexp. "*" and "?" do not match
+// "/", while "**" matches any number of directories.
+func globRegexp(pattern string) *regexp.Regexp {
+	var b bytes.Buffer
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,137 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	args = append([]string{"-c", "user.name=walter", "-c", "user.email=walter@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %s", args, err, out)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git(t, dir, "init", "-q")
+	os.MkdirAll(filepath.Join(dir, "services", "api"), 0755)
+	os.MkdirAll(filepath.Join(dir, "services", "web"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "services", "api", "main.go"), []byte("package main\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "services", "web", "index.js"), []byte("\n"), 0644)
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "first")
+	git(t, dir, "tag", "base")
+
+	ioutil.WriteFile(filepath.Join(dir, "services", "api", "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	git(t, dir, "commit", "-q", "-a", "-m", "second")
+
+	api := &Task{Name: "api", Command: "echo api", Directory: dir, Changes: []string{"services/api/**"}}
+	web := &Task{Name: "web", Command: "echo web", Directory: dir, Changes: []string{"services/web/**", "*.md"}}
+
+	for _, base := range []string{"", "base"} {
+		BaseRef = base
+		for _, task := range []*Task{api, web} {
+			task.Status = Init
+			task.Detail = ""
+			task.Run(context.Background(), func() {}, nil)
+		}
+
+		if api.Status != Succeeded {
+			t.Fatalf("api should run: %s", api.StatusName())
+		}
+		if web.Status != Skipped || web.Detail != "no changes in services/web/**, *.md" {
+			t.Fatalf("web should be skipped: %s %q", web.StatusName(), web.Detail)
+		}
+	}
+
+	// Tasks run when changes cannot be determined
+	BaseRef = "walter-does-not-exist"
+	defer func() { BaseRef = "" }()
+	web.Status = Init
+	web.Run(context.Background(), func() {}, nil)
+	if web.Status != Succeeded {
+		t.Fatalf("web should run: %s", web.StatusName())
+	}
+}
+
+func TestChangedFilesWithUnusualNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git(t, dir, "init", "-q")
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("\n"), 0644)
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "first")
+
+	os.MkdirAll(filepath.Join(dir, "my docs"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "my docs", "notes ü.md"), []byte("\n"), 0644)
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "second")
+
+	files, err := changedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "my docs/notes ü.md" {
+		t.Fatalf("files should be \"my docs/notes ü.md\", not %q", files)
+	}
+}
+
+func TestChangedFilesSinceMergeBase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git(t, dir, "init", "-q")
+	ioutil.WriteFile(filepath.Join(dir, "api.go"), []byte("package api\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "web.js"), []byte("\n"), 0644)
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "first")
+	git(t, dir, "branch", "base")
+
+	ioutil.WriteFile(filepath.Join(dir, "api.go"), []byte("package api\n\nfunc f() {}\n"), 0644)
+	git(t, dir, "commit", "-q", "-a", "-m", "feature")
+
+	git(t, dir, "checkout", "-q", "base")
+	ioutil.WriteFile(filepath.Join(dir, "web.js"), []byte("f()\n"), 0644)
+	git(t, dir, "commit", "-q", "-a", "-m", "base moved on")
+	git(t, dir, "checkout", "-q", "-")
+
+	BaseRef = "base"
+	defer func() { BaseRef = "" }()
+	files, err := changedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "api.go" {
+		t.Fatalf("files should be api.go, not %q", files)
+	}
+
+	// The result is cached for the run
+	os.Rename(filepath.Join(dir, ".git"), filepath.Join(dir, "git"))
+	if files, err := changedFiles(dir); err != nil || len(files) != 1 {
+		t.Fatalf("files should be cached: %q, %v", files, err)
+	}
+	ForgetChanges()
+	if _, err := changedFiles(dir); err == nil {
+		t.Fatal("changed files should be computed again after ForgetChanges")
+	}
+}
@@ -0,0 +1,583 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// A condition of only_if and unless is an expression evaluated by walter
+// itself:
+//
+//	env.BRANCH == "main" && tasks.test.status == "succeeded"
+//	!file_exists("dist/app") || changed("src/**", "go.mod")
+//	tasks["unit tests"].exit_code == 0 && env.TAG =~ "^v[0-9]+"
+//
+// A condition which is not an expression, e.g. "test -d /tmp", runs as a
+// shell command and is true when the command exits with 0.
+
+// errNotExpression is returned by parseCondition when the condition is not
+// an expression, so that it runs as a shell command.
+var errNotExpression = errors.New("not an expression")
+
+// Skip evaluates only_if, unless and changes of the task, which can also be
+// a parallel or serial block, and marks the task as skipped when it is not
+// to run.
+func (t *Task) Skip() (bool, error) {
+	if t.OnlyIf == "" && t.Unless == "" && len(t.Changes) == 0 {
+		return false, nil
+	}
+
+	skip, err := t.skipped()
+	if err != nil {
+		t.Status = Failed
+		t.Detail = err.Error()
+		return false, err
+	}
+	if skip {
+		t.Status = Skipped
+		log.Warnf("[%s] Skipped because %s", t.Name, t.Detail)
+	}
+	return skip, nil
+}
+
+// skipped evaluates only_if, unless and changes, and records the reason in Detail
+// when the task is to be skipped.
+func (t *Task) skipped() (bool, error) {
+	if t.OnlyIf != "" {
+		ok, err := t.evalCondition(t.OnlyIf)
+		if err != nil {
+			return false, fmt.Errorf("only_if: %s", err)
+		}
+		if !ok {
+			t.Detail = "only_if is false: " + t.OnlyIf
+			return true, nil
+		}
+	}
+
+	if t.Unless != "" {
+		ok, err := t.evalCondition(t.Unless)
+		if err != nil {
+			return false, fmt.Errorf("unless: %s", err)
+		}
+		if ok {
+			t.Detail = "unless is true: " + t.Unless
+			return true, nil
+		}
+	}
+
+	if len(t.Changes) > 0 && !t.changed() {
+		t.Detail = "no changes in " + strings.Join(t.Changes, ", ")
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (t *Task) evalCondition(s string) (bool, error) {
+	expr, err := parseCondition(s)
+	if err == errNotExpression {
+		return t.runCondition(s), nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	v, err := expr.eval(t)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is not a boolean", s)
+	}
+	return b, nil
+}
+
+func (t *Task) runCondition(s string) bool {
+	cmd := exec.Command("sh", "-c", s)
+	cmd.Dir = t.Directory
+	if err := RunHelper(cmd); err != nil {
+		log.Infof("[%s] %s: %s", t.Name, s, err)
+		return false
+	}
+	return true
+}
+
+type token struct {
+	kind string // ident, string, number or the operator itself
+	text string
+}
+
+var operators = []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ".", ","}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && (isIdentStart(s[j]) || isDigit(s[j]) || s[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, token{"ident", s[i:j]})
+			i = j
+		case isDigit(c):
+			j := i + 1
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{"number", s[i:j]})
+			i = j
+		case c == '"' || c == '\'':
+			str, n, err := readString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{"string", str})
+			i += n
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errNotExpression
+			}
+			tokens = append(tokens, token{op, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// readString reads a quoted string at the beginning of s. Double quoted
+// strings support \" and \\ escapes, single quoted ones are raw.
+func readString(s string) (string, int, error) {
+	quote := s[0]
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == quote:
+			return string(b), i + 1, nil
+		case quote == '"' && s[i] == '\\' && i+1 < len(s):
+			i++
+			b = append(b, s[i])
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return "", 0, errNotExpression
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type expr interface {
+	eval(t *Task) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+type envVar struct {
+	name string
+}
+
+type taskField struct {
+	task  string
+	field string
+}
+
+type call struct {
+	name string
+	args []expr
+}
+
+type not struct {
+	x expr
+}
+
+type binary struct {
+	op   string
+	x, y expr
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parseCondition(s string) (expr, error) {
+	e, err := parseExpression(s)
+	if err == errNotExpression && looksLikeExpression(s) {
+		return nil, fmt.Errorf("invalid expression: %s", s)
+	}
+	return e, err
+}
+
+// expressionStart matches conditions starting like an expression, e.g.
+// "env.BRANCH" or "!file_exists(", which are not run as shell commands even
+// when they are invalid.
+var expressionStart = regexp.MustCompile(`^[\s!(]*(env|tasks|file_exists|changed)\s*[.\[(]`)
+
+func looksLikeExpression(s string) bool {
+	return expressionStart.MatchString(s)
+}
+
+func parseExpression(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errNotExpression
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errNotExpression
+	}
+	return e, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(kind string) (token, error) {
+	if p.peek() != kind {
+		return token{}, errNotExpression
+	}
+	return p.next(), nil
+}
+
+func (p *parser) or() (expr, error) {
+	x, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var y expr
+		y, err = p.and()
+		x = &binary{"||", x, y}
+	}
+	return x, err
+}
+
+func (p *parser) and() (expr, error) {
+	x, err := p.comparison()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var y expr
+		y, err = p.comparison()
+		x = &binary{"&&", x, y}
+	}
+	return x, err
+}
+
+func (p *parser) comparison() (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=", "=~", "!~", "<", "<=", ">", ">=":
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op, x, y}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.peek() == "!" {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	switch p.peek() {
+	case "(":
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case "string":
+		return &literal{p.next().text}, nil
+	case "number":
+		f, err := strconv.ParseFloat(p.next().text, 64)
+		if err != nil {
+			return nil, errNotExpression
+		}
+		return &literal{f}, nil
+	case "ident":
+		return p.ident()
+	}
+	return nil, errNotExpression
+}
+
+func (p *parser) ident() (expr, error) {
+	name := p.next().text
+	switch name {
+	case "true":
+		return &literal{true}, nil
+	case "false":
+		return &literal{false}, nil
+	case "file_exists", "changed":
+		return p.call(name)
+	case "env":
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) != 1 {
+			return nil, errors.New("env must be followed by the name of a variable, e.g. env.HOME")
+		}
+		return &envVar{path[0]}, nil
+	case "tasks":
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) != 2 || !includes([]string{"status", "exit_code", "detail"}, path[1]) {
+			return nil, errors.New("tasks must be followed by a task name and status, exit_code or detail, e.g. tasks.test.status")
+		}
+		return &taskField{path[0], path[1]}, nil
+	}
+	return nil, errNotExpression
+}
+
+// path reads the keys following an identifier, e.g. .test.status or
+// ["unit tests"].status
+func (p *parser) path() ([]string, error) {
+	var path []string
+	for {
+		switch p.peek() {
+		case ".":
+			p.next()
+			t, err := p.expect("ident")
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, t.text)
+		case "[":
+			p.next()
+			t, err := p.expect("string")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, t.text)
+		default:
+			return path, nil
+		}
+	}
+}
+
+func (p *parser) call(name string) (expr, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	c := &call{name: name}
+	for p.peek() != ")" {
+		if len(c.args) > 0 {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+	}
+	p.next()
+
+	if len(c.args) == 0 || (name == "file_exists" && len(c.args) != 1) {
+		return nil, fmt.Errorf("wrong number of arguments for %s", name)
+	}
+	return c, nil
+}
+
+func (l *literal) eval(t *Task) (interface{}, error) {
+	return l.value, nil
+}
+
+func (e *envVar) eval(t *Task) (interface{}, error) {
+	return os.Getenv(e.name), nil
+}
+
+func (f *taskField) eval(t *Task) (interface{}, error) {
+	var other *Task
+	if t.Lookup != nil {
+		other = t.Lookup(f.task)
+	}
+	if other == nil {
+		return nil, fmt.Errorf("task %s has not finished", f.task)
+	}
+
+	switch f.field {
+	case "status":
+		return strings.ToLower(other.StatusName()), nil
+	case "exit_code":
+		return float64(other.ExitCode), nil
+	default:
+		return other.Detail, nil
+	}
+}
+
+func (c *call) eval(t *Task) (interface{}, error) {
+	var args []string
+	for _, a := range c.args {
+		v, err := a.eval(t)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("arguments of %s must be strings", c.name)
+		}
+		args = append(args, s)
+	}
+
+	if c.name == "file_exists" {
+		file := args[0]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(t.Directory, file)
+		}
+		return isExist(file), nil
+	}
+
+	files, err := changedFiles(t.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return matchGlobs(args, files), nil
+}
+
+func (n *not) eval(t *Task) (interface{}, error) {
+	v, err := n.x.eval(t)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, errors.New("operand of ! must be a boolean")
+	}
+	return !b, nil
+}
+
+func (b *binary) eval(t *Task) (interface{}, error) {
+	x, err := b.x.eval(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || do not evaluate the right operand when not needed
+	if b.op == "&&" || b.op == "||" {
+		bx, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operands of %s must be booleans", b.op)
+		}
+		if bx == (b.op == "||") {
+			return bx, nil
+		}
+		y, err := b.y.eval(t)
+		if err != nil {
+			return nil, err
+		}
+		by, ok := y.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operands of %s must be booleans", b.op)
+		}
+		return by, nil
+	}
+
+	y, err := b.y.eval(t)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "==", "!=":
+		if fmt.Sprintf("%T", x) != fmt.Sprintf("%T", y) {
+			return nil, fmt.Errorf("operands of %s must have the same type", b.op)
+		}
+		return (x == y) == (b.op == "=="), nil
+	case "=~", "!~":
+		s, ok1 := x.(string)
+		pattern, ok2 := y.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("operands of %s must be strings", b.op)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s) == (b.op == "=~"), nil
+	}
+
+	fx, ok1 := x.(float64)
+	fy, ok2 := y.(float64)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("operands of %s must be numbers", b.op)
+	}
+	switch b.op {
+	case "<":
+		return fx < fy, nil
+	case "<=":
+		return fx <= fy, nil
+	case ">":
+		return fx > fy, nil
+	default:
+		return fx >= fy, nil
+	}
+}
@@ -0,0 +1,116 @@
+package task
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseConditionFallsBackToShell(t *testing.T) {
+	for _, s := range []string{"test -d /tmp", "[ -f foo ]", "which go", "./check.sh", "env FOO=bar ./check.sh"} {
+		if _, err := parseCondition(s); err != errNotExpression {
+			t.Fatalf("%s should not be an expression: %v", s, err)
+		}
+	}
+
+	for _, s := range []string{"env", "tasks.test", "tasks.test.foo", "env.BRANCH ==", `env.BRANCH = "main"`, `!file_exists("a"`, `changed("src/**") &`} {
+		if _, err := parseCondition(s); err == nil || err == errNotExpression {
+			t.Fatalf("Error should be returned: %s", s)
+		}
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	os.Setenv("WALTER_TEST_BRANCH", "main")
+	defer os.Unsetenv("WALTER_TEST_BRANCH")
+
+	test := &Task{Name: "unit tests", Status: Succeeded}
+	lint := &Task{Name: "lint", Status: Failed, ExitCode: 2}
+	task := &Task{Name: "deploy", Directory: "/", Lookup: func(name string) *Task {
+		switch name {
+		case "unit tests":
+			return test
+		case "lint":
+			return lint
+		}
+		return nil
+	}}
+
+	cases := map[string]bool{
+		`true`:                             true,
+		`env.WALTER_TEST_BRANCH == "main"`: true,
+		`env.WALTER_TEST_BRANCH != 'main'`: false,
+		`env.WALTER_TEST_BRANCH =~ "^ma" && tasks["unit tests"].status == "succeeded"`: true,
+		`tasks.lint.status == "succeeded" || tasks.lint.exit_code >= 2`:                true,
+		`!(tasks.lint.exit_code == 2)`:                                                 false,
+		`file_exists("tmp") && !file_exists("walter-does-not-exist")`:                  true,
+		`false || test -d /tmp`:                                                        true,
+		`test -d /walter-does-not-exist`:                                               false,
+		`test -d /tmp`:                                                                 true,
+	}
+	for s, expected := range cases {
+		actual, err := task.evalCondition(s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if actual != expected {
+			t.Fatalf("%s should be %v", s, expected)
+		}
+	}
+
+	for _, s := range []string{`env.WALTER_TEST_BRANCH`, `tasks.build.status == "succeeded"`, `env.HOME && true`, `tasks.lint.exit_code < "3"`, `tasks.lint.exit_code == "2"`, `env.WALTER_TEST_BRANCH != 1`} {
+		if _, err := task.evalCondition(s); err == nil {
+			t.Fatalf("Error should be returned: %s", s)
+		}
+	}
+}
+
+func TestOnlyIfAndUnless(t *testing.T) {
+	task := &Task{Name: "only_if", Command: "echo foo", OnlyIf: `env.WALTER_TEST_UNSET == "yes"`}
+	if err := task.Run(context.Background(), func() {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != Skipped || task.Detail == "" || task.Stdout != nil {
+		t.Fatalf("Task should be skipped with a reason: %s %q", task.StatusName(), task.Detail)
+	}
+
+	task = &Task{Name: "unless", Command: "echo foo", Unless: `file_exists("/tmp")`}
+	task.Run(context.Background(), func() {}, nil)
+	if task.Status != Skipped {
+		t.Fatalf("Task should be skipped: %s", task.StatusName())
+	}
+
+	task = &Task{Name: "unless", Command: "echo foo", OnlyIf: "test -d /tmp", Unless: "false"}
+	task.Run(context.Background(), func() {}, nil)
+	if task.Status != Succeeded {
+		t.Fatalf("Task should succeed: %s", task.StatusName())
+	}
+
+	task = &Task{Name: "invalid", Command: "echo foo", OnlyIf: `tasks.test.foo == "bar"`}
+	cancelled := false
+	if err := task.Run(context.Background(), func() { cancelled = true }, nil); err == nil || task.Status != Failed || !cancelled {
+		t.Fatalf("Task should fail and cancel the pipeline: %s", task.StatusName())
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	cases := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"src/**", "src/a/b.go", true},
+		{"src/*.go", "src/a/b.go", false},
+		{"src/**/*.go", "src/b.go", true},
+		{"src/**/*.go", "src/a/b/c.go", true},
+		{"**/*.md", "README.md", true},
+		{"go.mod", "go.mod", true},
+		{"go.?od", "go.sum", false},
+	}
+	for _, c := range cases {
+		if globRegexp(c.pattern).MatchString(c.file) != c.match {
+			t.Fatalf("%s matching %s should be %v", c.pattern, c.file, c.match)
+		}
+	}
+}
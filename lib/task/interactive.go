@@ -0,0 +1,60 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// attachTerminal connects the terminal of walter to the command of the
+// task. The command stays in the foreground process group of walter so
+// that it can read from the terminal.
+func (t *Task) attachTerminal() error {
+	if !isTerminal(os.Stdin) {
+		return errors.New("interactive: walter is not attached to a terminal")
+	}
+
+	t.Cmd.Stdin = os.Stdin
+	t.Cmd.Stdout = os.Stdout
+	t.Cmd.Stderr = os.Stderr
+	t.Cmd.SysProcAttr = &syscall.SysProcAttr{}
+	return nil
+}
+
+// heldLogs buffers logs of walter while an interactive task owns the
+// terminal.
+type heldLogs struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (h *heldLogs) Write(b []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.buf.Write(b)
+}
+
+// pauseLogs holds logs and keeps Ctrl-C from killing walter until the
+// returned function is called. The interrupt still reaches the task.
+func pauseLogs() func() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	out := log.StandardLogger().Out
+	held := &heldLogs{}
+	log.SetOutput(held)
+
+	return func() {
+		signal.Stop(interrupt)
+		log.SetOutput(out)
+		held.mu.Lock()
+		defer held.mu.Unlock()
+		io.Copy(out, &held.buf)
+	}
+}
@@ -0,0 +1,26 @@
+package task
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestInteractiveWithoutTerminal(t *testing.T) {
+	if isTerminal(os.Stdin) {
+		t.Skip("stdin is a terminal")
+	}
+
+	tsk := &Task{Name: "interactive", Command: "echo foo", Interactive: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+
+	if tsk.Status != Failed {
+		t.Fatal("tsk.Status should be Failed")
+	}
+}
@@ -0,0 +1,103 @@
+package task
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Process is a process left behind by a task after it finished.
+type Process struct {
+	Pid     int
+	Command string
+}
+
+type registry struct {
+	mu       sync.Mutex
+	pids     map[int]bool
+	reported map[int]bool
+	// spawning is held for reading while a command is started and
+	// registered, and for writing while leaked processes are looked for,
+	// so that a command is never seen before it is registered
+	spawning sync.RWMutex
+}
+
+// running holds the pids of the commands currently started by tasks and by
+// walter itself, so that they are not mistaken for processes leaked by a
+// task. It also remembers the leaked processes already reported.
+var running = &registry{pids: map[int]bool{}, reported: map[int]bool{}}
+
+// start starts cmd and registers its process as running.
+func (r *registry) start(cmd *exec.Cmd) error {
+	r.spawning.RLock()
+	defer r.spawning.RUnlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	r.add(cmd.Process.Pid)
+	return nil
+}
+
+// RunHelper runs cmd, which walter runs for itself rather than for a task,
+// so that its process is not mistaken for a process leaked by a task.
+func RunHelper(cmd *exec.Cmd) error {
+	if err := running.start(cmd); err != nil {
+		return err
+	}
+	defer running.remove(cmd.Process.Pid)
+	return cmd.Wait()
+}
+
+func (r *registry) add(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pids[pid] = true
+}
+
+func (r *registry) remove(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pids, pid)
+}
+
+func (r *registry) has(pid int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pids[pid]
+}
+
+// report marks pid as reported as leaked, and reports whether it was not
+// reported before.
+func (r *registry) report(pid int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reported[pid] {
+		return false
+	}
+	r.reported[pid] = true
+	return true
+}
+
+// forget forgets pid, whose process has gone, so that the pid can be reported
+// again when it is reused.
+func (r *registry) forget(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reported, pid)
+}
+
+func (t *Task) checkLeaked() {
+	t.Leaked = findLeaked(t.Cmd.Process.Pid)
+	for _, p := range t.Leaked {
+		log.Warnf("[%s] leaked process %d: %s", t.Name, p.Pid, p.Command)
+		if t.KillLeaked {
+			syscall.Kill(p.Pid, syscall.SIGKILL)
+			reap(p.Pid)
+			running.forget(p.Pid)
+			log.Warnf("[%s] killed leaked process %d", t.Name, p.Pid)
+		}
+	}
+}
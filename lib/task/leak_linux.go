@@ -0,0 +1,156 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const prSetChildSubreaper = 36
+
+// EnableSubreaper registers walter as a child subreaper, so that background
+// processes orphaned by tasks are reparented to walter instead of init and
+// can be reported as leaked. Adopted processes are reaped when they exit.
+func EnableSubreaper() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	if errno != 0 {
+		return errno
+	}
+	startReaper.Do(func() { go reapAdopted() })
+	return nil
+}
+
+var startReaper sync.Once
+
+// reapAdopted reaps the exited processes adopted by walter on every SIGCHLD,
+// so that they do not stay zombies until walter exits.
+func reapAdopted() {
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	for range sigchld {
+		reapZombies()
+	}
+}
+
+// reapZombies reaps the exited children of walter which were not started by
+// walter itself, i.e. are not in the running registry.
+func reapZombies() {
+	running.spawning.Lock()
+	defer running.spawning.Unlock()
+
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+
+	self := os.Getpid()
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		if st, err := readStat(pid); err == nil && st.state == "Z" && st.ppid == self && !running.has(pid) {
+			reap(pid)
+			running.forget(pid)
+		}
+	}
+}
+
+type procStat struct {
+	pid   int
+	state string
+	ppid  int
+	pgid  int
+	comm  string
+}
+
+// findLeaked returns processes which are still alive in the process group of
+// a finished task, and processes adopted by walter which do not belong to any
+// running task. Processes already reported for another task are left out, and
+// adopted processes which have exited are reaped.
+func findLeaked(pgid int) []Process {
+	running.spawning.Lock()
+	defer running.spawning.Unlock()
+
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	self := os.Getpid()
+	var leaked []Process
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		st, err := readStat(pid)
+		if err != nil {
+			continue
+		}
+
+		if st.state == "Z" {
+			if st.ppid == self && !running.has(st.pid) {
+				reap(pid)
+				running.forget(pid)
+			}
+			continue
+		}
+
+		adopted := st.ppid == self && !running.has(st.pid) && !running.has(st.pgid)
+		if st.pgid != pgid && !adopted {
+			continue
+		}
+
+		if !running.report(pid) {
+			continue
+		}
+
+		leaked = append(leaked, Process{Pid: pid, Command: readCmdline(pid, st.comm)})
+	}
+
+	return leaked
+}
+
+func readStat(pid int) (procStat, error) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// comm is enclosed in parentheses and may contain spaces
+	s := string(data)
+	open := strings.Index(s, "(")
+	close := strings.LastIndex(s, ")")
+	if open < 0 || close < open {
+		return procStat{}, syscall.EINVAL
+	}
+
+	fields := strings.Fields(s[close+1:])
+	if len(fields) < 3 {
+		return procStat{}, syscall.EINVAL
+	}
+
+	st := procStat{pid: pid, comm: s[open+1 : close], state: fields[0]}
+	st.ppid, _ = strconv.Atoi(fields[1])
+	st.pgid, _ = strconv.Atoi(fields[2])
+	return st, nil
+}
+
+func readCmdline(pid int, comm string) string {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil || len(data) == 0 {
+		return comm
+	}
+	return strings.TrimSpace(strings.Replace(string(data), "\x00", " ", -1))
+}
+
+func reap(pid int) {
+	var ws syscall.WaitStatus
+	syscall.Wait4(pid, &ws, 0, nil)
+}
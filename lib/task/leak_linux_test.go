@@ -0,0 +1,94 @@
+package task
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestKillLeaked(t *testing.T) {
+	tsk := &Task{Name: "leak", Command: "sleep 30 >/dev/null 2>&1 &", KillLeaked: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tsk.Leaked) != 1 {
+		t.Fatalf("tsk.Leaked should have 1 process, not %d", len(tsk.Leaked))
+	}
+
+	p := tsk.Leaked[0]
+	if p.Command != "sleep 30" {
+		t.Fatalf("leaked command should be \"sleep 30\", not %q", p.Command)
+	}
+
+	for i := 0; i < 100; i++ {
+		if st, err := readStat(p.Pid); err != nil || st.state == "Z" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("leaked process %d should have been killed", p.Pid)
+}
+
+func TestLeakedReportedOnce(t *testing.T) {
+	if err := EnableSubreaper(); err != nil {
+		t.Skip(err)
+	}
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 0, 0)
+
+	a := &Task{Name: "a", Command: "setsid sleep 30 >/dev/null 2>&1 &"}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := a.Run(ctx, cancel, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Leaked) != 1 {
+		t.Fatalf("a.Leaked should have 1 process, not %d", len(a.Leaked))
+	}
+	pid := a.Leaked[0].Pid
+	defer func() {
+		syscall.Kill(pid, syscall.SIGKILL)
+		reap(pid)
+		running.forget(pid)
+	}()
+
+	b := &Task{Name: "b", Command: "true", KillLeaked: true}
+	if err := b.Run(ctx, cancel, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Leaked) != 0 {
+		t.Fatalf("b.Leaked should be empty, not %v", b.Leaked)
+	}
+	if st, err := readStat(pid); err != nil || st.state == "Z" {
+		t.Fatalf("process %d leaked by a should not have been killed by b", pid)
+	}
+}
+
+func TestAdoptedReaped(t *testing.T) {
+	if err := EnableSubreaper(); err != nil {
+		t.Skip(err)
+	}
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 0, 0)
+
+	tsk := &Task{Name: "adopt", Command: "setsid sleep 0.2 >/dev/null 2>&1 &"}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := tsk.Run(ctx, cancel, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(tsk.Leaked) != 1 {
+		t.Fatalf("tsk.Leaked should have 1 process, not %d", len(tsk.Leaked))
+	}
+
+	pid := tsk.Leaked[0].Pid
+	for i := 0; i < 200; i++ {
+		if _, err := readStat(pid); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("adopted process %d should have been reaped", pid)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+// EnableSubreaper is only supported on Linux.
+func EnableSubreaper() error {
+	return nil
+}
+
+func findLeaked(pgid int) []Process {
+	return nil
+}
+
+func reap(pid int) {}
@@ -0,0 +1,133 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Limits are resource limits applied to the process of a task.
+type Limits struct {
+	AddressSpace *uint64 `yaml:"address_space"`
+	OpenFiles    *uint64 `yaml:"open_files"`
+	Processes    *uint64 `yaml:"processes"`
+	CPU          *uint64 `yaml:"cpu"`
+	Core         *uint64 `yaml:"core"`
+}
+
+var ioniceClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+func (t *Task) hasLimits() bool {
+	return t.Limits != nil || t.Nice != 0 || t.Ionice != ""
+}
+
+// ioprio parses ionice, which is a class name optionally followed by a
+// level like "best-effort:7".
+func (t *Task) ioprio() (int, error) {
+	parts := strings.SplitN(t.Ionice, ":", 2)
+	class, ok := ioniceClasses[parts[0]]
+	if !ok {
+		return 0, errors.New("ionice: class does not support " + parts[0])
+	}
+
+	level := 0
+	if len(parts) == 2 {
+		var err error
+		level, err = strconv.Atoi(parts[1])
+		if err != nil || level < 0 || level > 7 {
+			return 0, errors.New("ionice: level must be between 0 and 7")
+		}
+	}
+
+	return class<<13 | level, nil
+}
+
+// gatedCommand returns a command which waits until limits are applied to
+// its process before running the command of the task. The returned file
+// releases the command when it is written to, or makes it exit when it is
+// closed without writing.
+func (t *Task) gatedCommand() (*exec.Cmd, *os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.Command("sh", "-c", "read _ <&3 || exit 1; exec 3<&-; "+t.Command)
+	cmd.ExtraFiles = []*os.File{r}
+	return cmd, w, nil
+}
+
+// releaseGate applies the limits to the started process and lets it run the
+// command of the task.
+func (t *Task) releaseGate(gate *os.File) error {
+	defer gate.Close()
+	t.Cmd.ExtraFiles[0].Close()
+
+	if err := t.applyLimits(t.Cmd.Process.Pid); err != nil {
+		return err
+	}
+	if t.Limits != nil {
+		log.Infof("[%s] limits: %s", t.Name, t.Limits)
+	}
+
+	_, err := gate.Write([]byte("\n"))
+	return err
+}
+
+// limitExceeded returns the reason when the process of the task, or a
+// process run by it, looks like it was killed by the kernel because of its
+// limits.
+func (t *Task) limitExceeded() string {
+	if t.Limits == nil {
+		return ""
+	}
+
+	ws := t.Cmd.ProcessState.Sys().(syscall.WaitStatus)
+	sig := ws.Signal()
+	if !ws.Signaled() {
+		// The shell exits with 128 plus the signal number when the
+		// command it runs is killed
+		if ws.ExitStatus() <= 128 {
+			return ""
+		}
+		sig = syscall.Signal(ws.ExitStatus() - 128)
+	}
+
+	switch sig {
+	case syscall.SIGXCPU:
+		return "limit exceeded: cpu"
+	case syscall.SIGKILL:
+		cpu := t.Cmd.ProcessState.UserTime() + t.Cmd.ProcessState.SystemTime()
+		if t.Limits.CPU != nil && cpu >= time.Duration(*t.Limits.CPU)*time.Second {
+			return "limit exceeded: cpu"
+		}
+	}
+
+	return ""
+}
+
+func (l *Limits) String() string {
+	var s []string
+	add := func(name string, v *uint64) {
+		if v != nil {
+			s = append(s, fmt.Sprintf("%s=%d", name, *v))
+		}
+	}
+	add("address_space", l.AddressSpace)
+	add("open_files", l.OpenFiles)
+	add("processes", l.Processes)
+	add("cpu", l.CPU)
+	add("core", l.Core)
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const rlimitNproc = 6
+
+func (t *Task) applyLimits(pid int) error {
+	if l := t.Limits; l != nil {
+		for _, r := range []struct {
+			name     string
+			resource int
+			value    *uint64
+		}{
+			{"address_space", syscall.RLIMIT_AS, l.AddressSpace},
+			{"open_files", syscall.RLIMIT_NOFILE, l.OpenFiles},
+			{"processes", rlimitNproc, l.Processes},
+			{"cpu", syscall.RLIMIT_CPU, l.CPU},
+			{"core", syscall.RLIMIT_CORE, l.Core},
+		} {
+			if r.value == nil {
+				continue
+			}
+			if err := prlimit(pid, r.resource, *r.value); err != nil {
+				return fmt.Errorf("limits: cannot set %s: %s", r.name, err)
+			}
+		}
+	}
+
+	if t.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, t.Nice); err != nil {
+			return fmt.Errorf("nice: %s", err)
+		}
+	}
+
+	if t.Ionice != "" {
+		prio, err := t.ioprio()
+		if err != nil {
+			return err
+		}
+		const ioprioWhoProcess = 1
+		_, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio))
+		if errno != 0 {
+			return fmt.Errorf("ionice: %s", errno)
+		}
+	}
+
+	return nil
+}
+
+func prlimit(pid int, resource int, value uint64) error {
+	lim := syscall.Rlimit{Cur: value, Max: value}
+	// SIGKILL is sent instead of SIGXCPU when both limits are reached
+	// at the same time
+	if resource == syscall.RLIMIT_CPU {
+		lim.Max++
+	}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
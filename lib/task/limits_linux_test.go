@@ -0,0 +1,71 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestLimits(t *testing.T) {
+	openFiles := uint64(16)
+	tsk := &Task{Name: "limits", Command: "ulimit -n; nice", Limits: &Limits{OpenFiles: &openFiles}, Nice: 5}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Stdout.String() != "16\n5\n" {
+		t.Fatalf("tsk.Stdout should be 16 and 5, not %q", tsk.Stdout)
+	}
+}
+
+func TestCPULimitExceeded(t *testing.T) {
+	cpu := uint64(1)
+	tsk := &Task{Name: "cpu", Command: "while :; do :; done", Limits: &Limits{CPU: &cpu}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+
+	if !strings.Contains(tsk.Detail, "limit exceeded: cpu") {
+		t.Fatalf("tsk.Detail should contain \"limit exceeded: cpu\", not %q", tsk.Detail)
+	}
+}
+
+func TestCPULimitExceededByChild(t *testing.T) {
+	cpu := uint64(1)
+	tsk := &Task{Name: "cpu", Command: "cd / && sh -c 'while :; do :; done'; exit $?", Limits: &Limits{CPU: &cpu}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+
+	if !strings.Contains(tsk.Detail, "limit exceeded: cpu") {
+		t.Fatalf("tsk.Detail should contain \"limit exceeded: cpu\", not %q", tsk.Detail)
+	}
+}
+
+func TestIoprio(t *testing.T) {
+	tsk := &Task{Ionice: "best-effort:7"}
+	prio, err := tsk.ioprio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prio != 2<<13|7 {
+		t.Fatalf("ioprio should be %d, not %d", 2<<13|7, prio)
+	}
+
+	for _, v := range []string{"foo", "idle:8", "idle:x"} {
+		tsk := &Task{Ionice: v}
+		if _, err := tsk.ioprio(); err == nil {
+			t.Fatalf("Error should be returned: %s", v)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+import "errors"
+
+func (t *Task) applyLimits(pid int) error {
+	return errors.New("limits, nice and ionice are only supported on Linux")
+}
@@ -0,0 +1,178 @@
+package task
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// OutputMemoryLimit is the number of bytes of each output kept in memory.
+// Outputs larger than this are spilled to temporary files.
+var OutputMemoryLimit = 1024 * 1024
+
+// Output stores a stream of task output. It keeps the last
+// OutputMemoryLimit bytes in memory and, once the stream grows larger,
+// writes the whole stream to a temporary file.
+type Output struct {
+	mu    sync.Mutex
+	limit int
+	size  int64
+	tail  []byte
+	file  *os.File
+}
+
+var spilled = struct {
+	mu    sync.Mutex
+	files []*os.File
+}{}
+
+func NewOutput() *Output {
+	return &Output{limit: OutputMemoryLimit}
+}
+
+func (o *Output) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil && len(o.tail)+len(b) > o.limit {
+		if err := o.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	if o.file != nil {
+		if _, err := o.file.Write(b); err != nil {
+			return 0, err
+		}
+	}
+
+	o.size += int64(len(b))
+	o.tail = append(o.tail, b...)
+	// Trim the tail only when it doubled to avoid copying on every write
+	if o.file != nil && len(o.tail) > 2*o.limit {
+		o.tail = append([]byte{}, o.tail[len(o.tail)-o.limit:]...)
+	}
+
+	return len(b), nil
+}
+
+func (o *Output) spill() error {
+	f, err := ioutil.TempFile("", "walter-output-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(o.tail); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	spilled.mu.Lock()
+	spilled.files = append(spilled.files, f)
+	spilled.mu.Unlock()
+
+	o.file = f
+	return nil
+}
+
+// Reader returns a reader of the whole stream from the beginning.
+func (o *Output) Reader() (io.ReadCloser, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return ioutil.NopCloser(bytes.NewReader(append([]byte{}, o.tail...))), nil
+	}
+	f, err := os.Open(o.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, o.size), f}, nil
+}
+
+// Tail returns the last bytes of the stream kept in memory.
+func (o *Output) Tail() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.tail) > o.limit {
+		return append([]byte{}, o.tail[len(o.tail)-o.limit:]...)
+	}
+	return append([]byte{}, o.tail...)
+}
+
+// Len returns the size of the whole stream.
+func (o *Output) Len() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+// Since returns the part of the stream written after offset.
+func (o *Output) Since(offset int64) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if offset >= o.size {
+		return nil, nil
+	}
+	start := o.size - int64(len(o.tail))
+	if offset >= start {
+		return append([]byte{}, o.tail[offset-start:]...), nil
+	}
+	b := make([]byte, o.size-offset)
+	_, err := o.file.ReadAt(b, offset)
+	return b, err
+}
+
+// String returns the whole stream, or its tail when it cannot be read.
+func (o *Output) String() string {
+	r, err := o.Reader()
+	if err != nil {
+		return string(o.Tail())
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(o.Tail())
+	}
+	return string(b)
+}
+
+// Append writes the whole stream of src to o.
+func (o *Output) Append(src *Output) error {
+	if src == nil {
+		return nil
+	}
+
+	r, err := src.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(o, r)
+	return err
+}
+
+// RemoveOutputs removes the temporary files of spilled outputs, and forgets
+// the outputs of the tasks of the run.
+func RemoveOutputs() {
+	forgetOutputs()
+
+	spilled.mu.Lock()
+	defer spilled.mu.Unlock()
+
+	for _, f := range spilled.files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	spilled.files = nil
+}
@@ -0,0 +1,64 @@
+package task
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOutputSpill(t *testing.T) {
+	limit := OutputMemoryLimit
+	OutputMemoryLimit = 10
+	defer func() { OutputMemoryLimit = limit }()
+
+	o := NewOutput()
+	for i := 0; i < 10; i++ {
+		o.Write([]byte("0123456789\n"))
+	}
+
+	if o.file == nil {
+		t.Fatal("output should have been spilled to a file")
+	}
+
+	if o.Len() != 110 {
+		t.Fatalf("o.Len() should be 110, not %d", o.Len())
+	}
+
+	if o.String() != strings.Repeat("0123456789\n", 10) {
+		t.Fatalf("o.String() should return the whole stream, not %q", o.String())
+	}
+
+	if string(o.Tail()) != "123456789\n" {
+		t.Fatalf("o.Tail() should return the last 10 bytes, not %q", o.Tail())
+	}
+
+	if b, err := o.Since(5); err != nil || string(b) != strings.Repeat("0123456789\n", 10)[5:] {
+		t.Fatalf("o.Since(5) should return the stream from the file, not %q, %v", b, err)
+	}
+
+	if b, err := o.Since(105); err != nil || string(b) != "6789\n" {
+		t.Fatalf("o.Since(105) should return the last 5 bytes, not %q, %v", b, err)
+	}
+
+	name := o.file.Name()
+	RemoveOutputs()
+	if _, err := os.Stat(name); err == nil {
+		t.Fatalf("%s should have been removed", name)
+	}
+}
+
+func TestOutputInMemory(t *testing.T) {
+	o := NewOutput()
+	o.Write([]byte("hello"))
+
+	if o.file != nil {
+		t.Fatal("output should not have been spilled to a file")
+	}
+
+	dst := NewOutput()
+	dst.Append(o)
+	dst.Append(nil)
+	if dst.String() != "hello" {
+		t.Fatalf("dst.String() should be hello, not %q", dst.String())
+	}
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processRunning reports whether a process whose command name is name is
+// running. The name is compared with both the comm of the process, which is
+// truncated to 15 characters, and the base name of its first argument.
+func processRunning(name string) bool {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+
+		st, err := readStat(pid)
+		if err != nil || st.state == "Z" {
+			continue
+		}
+		if st.comm == name {
+			return true
+		}
+
+		data, err := ioutil.ReadFile("/proc/" + d.Name() + "/cmdline")
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if filepath.Base(strings.SplitN(string(data), "\x00", 2)[0]) == name {
+			return true
+		}
+	}
+
+	return false
+}
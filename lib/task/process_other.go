@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+import "os/exec"
+
+// processRunning reports whether a process whose command name is name is
+// running.
+func processRunning(name string) bool {
+	return RunHelper(exec.Command("pgrep", "-x", name)) == nil
+}
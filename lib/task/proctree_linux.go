@@ -0,0 +1,51 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// processTree returns the tree of processes under pid read from /proc.
+func processTree(pid int) string {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return ""
+	}
+
+	stats := map[int]procStat{}
+	children := map[int][]int{}
+	for _, d := range dirs {
+		p, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		st, err := readStat(p)
+		if err != nil {
+			continue
+		}
+		stats[p] = st
+		children[st.ppid] = append(children[st.ppid], p)
+	}
+
+	var buf bytes.Buffer
+	var walk func(pid, depth int)
+	walk = func(pid, depth int) {
+		st, ok := stats[pid]
+		if !ok {
+			return
+		}
+		fmt.Fprintf(&buf, "%s%d %s %s\n", strings.Repeat("  ", depth), pid, st.state, readCmdline(pid, st.comm))
+
+		sort.Ints(children[pid])
+		for _, c := range children[pid] {
+			walk(c, depth+1)
+		}
+	}
+	walk(pid, 0)
+
+	return buf.String()
+}
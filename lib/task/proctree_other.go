@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+// processTree is only supported on Linux.
+func processTree(pid int) string {
+	return ""
+}
@@ -0,0 +1,99 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+type pty struct {
+	master *os.File
+	slave  *os.File
+}
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func openPty() (*pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	// Keep "\n" as it is instead of translating it to "\r\n"
+	var termios syscall.Termios
+	if err := ioctl(slave, syscall.TCGETS, unsafe.Pointer(&termios)); err == nil {
+		termios.Oflag &^= syscall.ONLCR
+		ioctl(slave, syscall.TCSETS, unsafe.Pointer(&termios))
+	}
+
+	p := &pty{master: master, slave: slave}
+	p.resize()
+	return p, nil
+}
+
+// resize sets the size of the pty to the size of the terminal of walter.
+func (p *pty) resize() {
+	ws := winsize{Row: 24, Col: 80}
+	for _, f := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		if ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)) == nil {
+			break
+		}
+	}
+	ioctl(p.master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// watchResize resizes the pty when the terminal of walter is resized until
+// done is closed.
+func (p *pty) watchResize(done <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				p.resize()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)) == nil
+}
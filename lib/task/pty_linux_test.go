@@ -0,0 +1,36 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTTY(t *testing.T) {
+	tsk := &Task{Name: "tty", Command: "test -t 0 && test -t 1 && test -t 2 && echo tty && echo err 1>&2", TTY: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Stdout.String() != "tty\nerr\n" {
+		t.Fatalf("tsk.Stdout should be \"tty\\nerr\\n\", not %q", tsk.Stdout)
+	}
+}
+
+func TestTTYWithStdin(t *testing.T) {
+	tsk := &Task{Name: "tty", Command: "cat", TTY: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, strings.NewReader("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Stdout.String() != "hello\n" {
+		t.Fatalf("tsk.Stdout should be \"hello\\n\", not %q", tsk.Stdout)
+	}
+}
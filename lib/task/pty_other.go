@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+import (
+	"errors"
+	"os"
+)
+
+type pty struct {
+	master *os.File
+	slave  *os.File
+}
+
+func openPty() (*pty, error) {
+	return nil, errors.New("tty is only supported on Linux")
+}
+
+func (p *pty) watchResize(done <-chan struct{}) {}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,110 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// rules holds compiled fail_on, succeed_on and warn_on patterns and the
+// lines of output which matched them.
+type rules struct {
+	mu        sync.Mutex
+	failOn    []*regexp.Regexp
+	succeedOn []*regexp.Regexp
+	warnOn    []*regexp.Regexp
+	failure   string
+	success   string
+}
+
+func (t *Task) compileRules() error {
+	r := &rules{}
+
+	var err error
+	if r.failOn, err = compile("fail_on", t.FailOn); err != nil {
+		return err
+	}
+	if r.succeedOn, err = compile("succeed_on", t.SucceedOn); err != nil {
+		return err
+	}
+	if r.warnOn, err = compile("warn_on", t.WarnOn); err != nil {
+		return err
+	}
+
+	t.rules = r
+	t.Warnings = nil
+	return nil
+}
+
+func compile(key string, patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (t *Task) matchLine(line string) {
+	if t.TTY || t.StripANSI {
+		line = StripANSI(line)
+	}
+
+	r := t.rules
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failure == "" && matchAny(r.failOn, line) {
+		r.failure = line
+		log.Errorf("[%s] output matched fail_on: %s", t.Name, line)
+	}
+
+	if r.success == "" && matchAny(r.succeedOn, line) {
+		r.success = line
+		log.Infof("[%s] output matched succeed_on: %s", t.Name, line)
+	}
+
+	if matchAny(r.warnOn, line) {
+		t.Warnings = append(t.Warnings, line)
+		log.Warnf("[%s] warning: %s", t.Name, line)
+	}
+}
+
+func matchAny(res []*regexp.Regexp, line string) bool {
+	for _, re := range res {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanLines passes complete lines in b to the rules of the task and keeps an
+// incomplete last line until the next write.
+func (o *outputHandler) scanLines(b []byte) {
+	o.rest = append(o.rest, b...)
+	for {
+		i := bytes.IndexByte(o.rest, '\n')
+		if i < 0 {
+			return
+		}
+		o.task.matchLine(strings.TrimSuffix(string(o.rest[:i]), "\r"))
+		o.rest = o.rest[i+1:]
+	}
+}
+
+func (o *outputHandler) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.rest) > 0 {
+		o.task.matchLine(string(o.rest))
+		o.rest = nil
+	}
+}
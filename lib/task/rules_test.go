@@ -0,0 +1,67 @@
+package task
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFailOn(t *testing.T) {
+	tsk := &Task{Name: "fail_on", Command: "echo 'ERROR: something wrong'", FailOn: []string{"^ERROR"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+
+	if tsk.Status != Failed {
+		t.Fatal("tsk.Status should be Failed")
+	}
+
+	if tsk.Detail != "output matched fail_on: ERROR: something wrong" {
+		t.Fatalf("tsk.Detail should contain the matching line, not %q", tsk.Detail)
+	}
+}
+
+func TestSucceedOn(t *testing.T) {
+	tsk := &Task{Name: "succeed_on", Command: "echo 'nothing to do'; exit 1", SucceedOn: []string{"nothing to do"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Status != Succeeded {
+		t.Fatal("tsk.Status should be Succeeded")
+	}
+}
+
+func TestWarnOn(t *testing.T) {
+	tsk := &Task{Name: "warn_on", Command: "printf 'WARNING: a\\nok\\nWARNING: b' 1>&2", WarnOn: []string{"^WARNING"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tsk.Warnings) != 2 {
+		t.Fatalf("tsk.Warnings should have 2 lines, not %v", tsk.Warnings)
+	}
+
+	if tsk.Warnings[1] != "WARNING: b" {
+		t.Fatalf("last warning should be \"WARNING: b\", not %q", tsk.Warnings[1])
+	}
+}
+
+func TestInvalidRule(t *testing.T) {
+	tsk := &Task{Name: "invalid", Command: "echo foo", FailOn: []string{"("}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+}
@@ -0,0 +1,78 @@
+package task
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func (t *Task) touchOutput() {
+	atomic.StoreInt64(&t.lastOutput, time.Now().UnixNano())
+}
+
+// touchWriter writes output of the task which is not captured, like stdout
+// streamed to the next task, and marks it as output.
+type touchWriter struct {
+	task   *Task
+	writer io.Writer
+}
+
+func (w *touchWriter) Write(b []byte) (int, error) {
+	w.task.touchOutput()
+	return w.writer.Write(b)
+}
+
+func (t *Task) sinceOutput() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&t.lastOutput)))
+}
+
+// watchOutput returns a channel which is closed when the task did not write
+// any output for no_output_timeout. It warns when no_output_warning passed,
+// which defaults to half of no_output_timeout.
+func (t *Task) watchOutput(done <-chan struct{}) <-chan struct{} {
+	if t.NoOutputTimeout <= 0 {
+		return nil
+	}
+
+	timeout := time.Duration(t.NoOutputTimeout * float64(time.Second))
+	warning := timeout / 2
+	if t.NoOutputWarning > 0 {
+		warning = time.Duration(t.NoOutputWarning * float64(time.Second))
+	}
+
+	interval := timeout / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+
+	stalled := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		warned := false
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			since := t.sinceOutput()
+			switch {
+			case since >= timeout:
+				close(stalled)
+				return
+			case since >= warning && !warned:
+				log.Warnf("[%s] no output for %s", t.Name, since.Truncate(time.Second))
+				warned = true
+			case since < warning:
+				warned = false
+			}
+		}
+	}()
+
+	return stalled
+}
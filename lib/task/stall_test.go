@@ -0,0 +1,65 @@
+package task
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestNoOutputTimeout(t *testing.T) {
+	tsk := &Task{Name: "stall", Command: "echo start; sleep 10", NoOutputTimeout: 0.5, DumpProcessTree: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("task should have been stopped after no_output_timeout")
+	}
+
+	if tsk.Status != Stalled {
+		t.Fatal("tsk.Status should be Stalled")
+	}
+
+	if !strings.HasPrefix(tsk.Detail, "no output for 0.5s") {
+		t.Fatalf("tsk.Detail should start with \"no output for 0.5s\", not %q", tsk.Detail)
+	}
+}
+
+func TestNoOutputTimeoutWithOutput(t *testing.T) {
+	tsk := &Task{Name: "busy", Command: "for i in 1 2 3 4 5; do echo $i; sleep 0.2; done", NoOutputTimeout: 0.5}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Status != Succeeded {
+		t.Fatal("tsk.Status should be Succeeded")
+	}
+}
+
+func TestNoOutputTimeoutWithStream(t *testing.T) {
+	producer := &Task{Name: "producer", Command: "for i in 1 2 3 4 5; do echo $i; sleep 0.2; done", NoOutputTimeout: 0.5}
+	consumer := &Task{Name: "consumer", Command: "wc -l"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := RunStream(ctx, cancel, producer, consumer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if producer.Status != Succeeded {
+		t.Fatalf("producer.Status should be Succeeded, not %s", producer.StatusName())
+	}
+
+	if strings.TrimSpace(consumer.Stdout.String()) != "5" {
+		t.Fatalf("consumer.Stdout should be 5, not %s", consumer.Stdout)
+	}
+}
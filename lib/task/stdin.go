@@ -0,0 +1,66 @@
+package task
+
+import "errors"
+
+// Stdin defines where a task reads its stdin from. Without stdin, a task
+// reads stdout of the previous task.
+//
+//	stdin: none
+//	stdin: literal text
+//	stdin: {task: build, stream: stderr}
+//	stdin: {file: input.txt}
+type Stdin struct {
+	None    bool
+	Task    string
+	Stream  string
+	File    string
+	Literal string
+}
+
+func (s *Stdin) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		if str == "none" {
+			s.None = true
+		} else {
+			s.Literal = str
+		}
+		return nil
+	}
+
+	type plain Stdin
+	return unmarshal((*plain)(s))
+}
+
+// Validate returns an error when more than one source is defined.
+func (s *Stdin) Validate() error {
+	n := 0
+	for _, set := range []bool{s.None, s.Task != "", s.File != "", s.Literal != ""} {
+		if set {
+			n++
+		}
+	}
+
+	switch {
+	case n > 1:
+		return errors.New("stdin: cannot use none, task, file and literal at the same time")
+	case s.Stream != "" && s.Task == "":
+		return errors.New("stdin: cannot use stream without task")
+	case !includes([]string{"", "stdout", "stderr", "combined"}, s.Stream):
+		return errors.New("stdin: stream does not support " + s.Stream)
+	}
+
+	return nil
+}
+
+// Output returns stdout, stderr or combined output of the task.
+func (t *Task) Output(stream string) *Output {
+	switch stream {
+	case "stderr":
+		return t.Stderr
+	case "combined":
+		return t.CombinedOutput
+	default:
+		return t.Stdout
+	}
+}
@@ -0,0 +1,60 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestUnmarshalStdin(t *testing.T) {
+	var tasks []*Task
+	err := yaml.Unmarshal([]byte(`
+- name: none
+  stdin: none
+- name: literal
+  stdin: hello
+- name: task
+  stdin:
+    task: build
+    stream: stderr
+- name: file
+  stdin:
+    file: input.txt
+`), &tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tasks[0].Stdin.None {
+		t.Fatal("stdin should be none")
+	}
+
+	if tasks[1].Stdin.Literal != "hello" {
+		t.Fatalf("stdin literal should be hello, not %s", tasks[1].Stdin.Literal)
+	}
+
+	if tasks[2].Stdin.Task != "build" || tasks[2].Stdin.Stream != "stderr" {
+		t.Fatalf("stdin should be stderr of build: %#v", tasks[2].Stdin)
+	}
+
+	if tasks[3].Stdin.File != "input.txt" {
+		t.Fatalf("stdin file should be input.txt, not %s", tasks[3].Stdin.File)
+	}
+}
+
+func TestValidateStdin(t *testing.T) {
+	s := &Stdin{Task: "build", File: "input.txt"}
+	if s.Validate() == nil {
+		t.Fatalf("Error should be returned: %#v", s)
+	}
+
+	s = &Stdin{File: "input.txt", Stream: "stderr"}
+	if s.Validate() == nil {
+		t.Fatalf("Error should be returned: %#v", s)
+	}
+
+	s = &Stdin{Task: "build", Stream: "foo"}
+	if s.Validate() == nil {
+		t.Fatalf("Error should be returned: %#v", s)
+	}
+}
@@ -0,0 +1,81 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+func (t *Task) stopSignal() (syscall.Signal, error) {
+	if t.StopSignal == "" {
+		return syscall.SIGTERM, nil
+	}
+
+	name := strings.ToUpper(t.StopSignal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signals[name]
+	if !ok {
+		return 0, errors.New("stop_signal: unsupported signal " + t.StopSignal)
+	}
+	return sig, nil
+}
+
+func (t *Task) stopTimeout() time.Duration {
+	if t.StopTimeout > 0 {
+		return time.Duration(t.StopTimeout * float64(time.Second))
+	}
+	return defaultStopTimeout
+}
+
+// stop sends the stop signal to the process group of the task and escalates
+// to SIGKILL if the task does not exit within the stop timeout. Interactive
+// tasks share the process group of walter, so only the process is signaled.
+func (t *Task) stop(sig syscall.Signal, done <-chan struct{}) {
+	target := -t.Cmd.Process.Pid
+	if t.Interactive {
+		target = t.Cmd.Process.Pid
+	}
+
+	syscall.Kill(target, sig)
+	if sig == syscall.SIGKILL {
+		t.Detail = "stopped by SIGKILL"
+		return
+	}
+
+	timeout := t.stopTimeout()
+	select {
+	case <-done:
+		t.Detail = fmt.Sprintf("stopped by %s", signalName(sig))
+		return
+	case <-time.After(timeout):
+	}
+
+	syscall.Kill(target, syscall.SIGKILL)
+	t.Detail = fmt.Sprintf("stopped by SIGKILL after %s did not stop it within %s", signalName(sig), timeout)
+}
+
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// RunStream runs producer and consumer at the same time, connecting stdout of
+// producer to stdin of consumer through an OS pipe. When one of them fails,
+// the pair is marked as failed.
+func RunStream(ctx context.Context, cancel context.CancelFunc, producer, consumer *Task, stdin io.Reader) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		producer.Status = Failed
+		return err
+	}
+	producer.pipeOut = w
+	consumer.pipeIn = r
+
+	var wg sync.WaitGroup
+	var perr, cerr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		perr = producer.Run(ctx, cancel, stdin)
+	}()
+	go func() {
+		defer wg.Done()
+		cerr = consumer.Run(ctx, cancel, nil)
+	}()
+	wg.Wait()
+
+	switch {
+	case producer.Status == Failed && consumer.Status == Succeeded:
+		consumer.Status = Failed
+		consumer.Detail = fmt.Sprintf("%s piped to this task failed", producer.Name)
+	case consumer.Status == Failed && producer.Status == Succeeded:
+		producer.Status = Failed
+		producer.Detail = fmt.Sprintf("%s reading from this task failed", consumer.Name)
+	}
+
+	if perr != nil {
+		return perr
+	}
+	if cerr != nil {
+		return cerr
+	}
+	if producer.Status == Failed || consumer.Status == Failed {
+		cancel()
+		return errors.New("Piped task failed")
+	}
+
+	return nil
+}
+
+func (t *Task) closePipe() {
+	if t.pipeIn != nil {
+		t.pipeIn.Close()
+		t.pipeIn = nil
+	}
+	if t.pipeOut != nil {
+		t.pipeOut.Close()
+		t.pipeOut = nil
+	}
+}
+
+// closeInheritedPipe closes the ends of the pipes which the started command
+// inherited. Stdout copied by walter is closed once the command exits.
+func (t *Task) closeInheritedPipe() {
+	if t.pipeIn != nil {
+		t.pipeIn.Close()
+		t.pipeIn = nil
+	}
+	if t.pipeOut != nil && t.Cmd.Stdout == io.Writer(t.pipeOut) {
+		t.pipeOut.Close()
+		t.pipeOut = nil
+	}
+}
@@ -0,0 +1,46 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRunStream(t *testing.T) {
+	producer := &Task{Name: "producer", Command: "seq 1 100000"}
+	consumer := &Task{Name: "consumer", Command: "wc -l"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := RunStream(ctx, cancel, producer, consumer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(consumer.Stdout.String()) != "100000" {
+		t.Fatalf("consumer.Stdout should be 100000, not %s", consumer.Stdout)
+	}
+
+	if producer.Stdout.Len() != 0 {
+		t.Fatal("producer.Stdout should not be captured")
+	}
+}
+
+func TestRunStreamFailure(t *testing.T) {
+	producer := &Task{Name: "producer", Command: "echo foo; exit 1"}
+	consumer := &Task{Name: "consumer", Command: "cat >/dev/null"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := RunStream(ctx, cancel, producer, consumer, nil)
+	if err == nil {
+		t.Fatal("RunStream() should return err")
+	}
+
+	if producer.Status != Failed {
+		t.Fatal("producer.Status should be Failed")
+	}
+
+	if consumer.Status != Failed && consumer.Status != Aborted {
+		t.Fatal("consumer.Status should be Failed or Aborted")
+	}
+}
@@ -1,8 +1,7 @@
 package task
 
 import (
-	"bytes"
-	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -10,6 +9,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -23,22 +23,76 @@ const (
 	Failed
 	Skipped
 	Aborted
+	Stalled
 )
 
+var statusNames = map[int]string{
+	Init:      "Init",
+	Running:   "Running",
+	Succeeded: "Succeeded",
+	Failed:    "Failed",
+	Skipped:   "Skipped",
+	Aborted:   "Aborted",
+	Stalled:   "Stalled",
+}
+
+// StatusName returns the name of the status of the task.
+func (t *Task) StatusName() string {
+	return statusNames[t.Status]
+}
+
 type Task struct {
-	Name           string
-	Command        string
-	Directory      string
-	Parallel       []*Task
-	Serial         []*Task
-	Stdout         *bytes.Buffer
-	Stderr         *bytes.Buffer
-	CombinedOutput *bytes.Buffer
-	Status         int
-	Cmd            *exec.Cmd
-	Include        string
-	OnlyIf         string   `yaml:"only_if"`
-	WaitFor        *WaitFor `yaml:"wait_for"`
+	Name            string
+	Command         string
+	Directory       string
+	Parallel        []*Task
+	Serial          []*Task
+	Stdout          *Output
+	Stderr          *Output
+	CombinedOutput  *Output
+	Status          int
+	Cmd             *exec.Cmd
+	Use             string            `yaml:"use"`
+	With            map[string]string `yaml:"with"`
+	Include         string
+	OnlyIf          string   `yaml:"only_if"`
+	Unless          string   `yaml:"unless"`
+	Changes         []string `yaml:"changes"`
+	WaitFor         *WaitFor `yaml:"wait_for"`
+	StopSignal      string   `yaml:"stop_signal"`
+	StopTimeout     float64  `yaml:"stop_timeout"`
+	KillLeaked      bool     `yaml:"kill_leaked"`
+	FailFast        *bool    `yaml:"fail_fast"`
+	MaxParallel     int      `yaml:"max_parallel"`
+	Locks           []string `yaml:"locks"`
+	LockTimeout     float64  `yaml:"lock_timeout"`
+	PipeToNext      string   `yaml:"pipe_to_next"`
+	Stdin           *Stdin   `yaml:"stdin"`
+	SuccessCodes    []int    `yaml:"success_codes"`
+	SkipCodes       []int    `yaml:"skip_codes"`
+	FailOn          []string `yaml:"fail_on"`
+	SucceedOn       []string `yaml:"succeed_on"`
+	WarnOn          []string `yaml:"warn_on"`
+	NoOutputTimeout float64  `yaml:"no_output_timeout"`
+	NoOutputWarning float64  `yaml:"no_output_warning"`
+	DumpProcessTree bool     `yaml:"dump_process_tree"`
+	Limits          *Limits  `yaml:"limits"`
+	Nice            int      `yaml:"nice"`
+	Ionice          string   `yaml:"ionice"`
+	TTY             bool     `yaml:"tty"`
+	StripANSI       bool     `yaml:"strip_ansi"`
+	Interactive     bool     `yaml:"interactive"`
+	Detail          string
+	ExitCode        int
+	Signal          string
+	Leaked          []Process
+	Warnings        []string
+	Usage           Usage
+	Lookup          func(name string) *Task `yaml:"-"`
+	rules           *rules
+	lastOutput      int64
+	pipeIn          *os.File
+	pipeOut         *os.File
 }
 
 type outputHandler struct {
@@ -46,9 +100,12 @@ type outputHandler struct {
 	writer io.Writer
 	copy   io.Writer
 	mu     *sync.Mutex
+	rest   []byte
 }
 
-func (t *Task) Run(ctx context.Context, cancel context.CancelFunc, prevTask *Task) error {
+func (t *Task) Run(ctx context.Context, cancel context.CancelFunc, stdin io.Reader) error {
+	defer t.closePipe()
+
 	if t.Command == "" {
 		return nil
 	}
@@ -62,91 +119,260 @@ func (t *Task) Run(ctx context.Context, cancel context.CancelFunc, prevTask *Tas
 		}
 	}
 
-	if t.OnlyIf != "" {
-		cmd := exec.Command("sh", "-c", t.OnlyIf)
-		cmd.Dir = t.Directory
-		err := cmd.Run()
-
+	if skip, err := t.Skip(); err != nil || skip {
 		if err != nil {
-			log.Warnf("[%s] Skipped because only_if failed: %s", t.Name, err)
-			return nil
+			cancel()
 		}
+		return err
 	}
 
 	if t.WaitFor != nil {
-		err := t.wait()
-		if err != nil {
+		err := t.wait(ctx, cancel)
+		if err != nil || t.Status == Aborted {
 			return err
 		}
 	}
 
+	sig, err := t.stopSignal()
+	if err != nil {
+		t.Status = Failed
+		return err
+	}
+
+	if err := t.compileRules(); err != nil {
+		t.Status = Failed
+		return err
+	}
+
 	log.Infof("[%s] Start task", t.Name)
 
-	t.Cmd = exec.Command("sh", "-c", t.Command)
+	start := time.Now()
+	defer t.recordUsage(start)
+
+	var gate *os.File
+	if t.hasLimits() {
+		t.Cmd, gate, err = t.gatedCommand()
+		if err != nil {
+			t.Status = Failed
+			return err
+		}
+	} else {
+		t.Cmd = exec.Command("sh", "-c", t.Command)
+	}
 	t.Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	t.Cmd.Dir = t.Directory
 
-	if prevTask != nil && prevTask.Stdout != nil {
-		t.Cmd.Stdin = bytes.NewBuffer(prevTask.Stdout.Bytes())
+	if t.pipeIn != nil {
+		t.Cmd.Stdin = t.pipeIn
+	} else if stdin != nil {
+		t.Cmd.Stdin = stdin
 	}
 
-	t.Stdout = new(bytes.Buffer)
-	t.Stderr = new(bytes.Buffer)
-	t.CombinedOutput = new(bytes.Buffer)
+	t.Stdout = NewOutput()
+	t.Stderr = NewOutput()
+	t.CombinedOutput = NewOutput()
+	registerOutput(t.Name, t.CombinedOutput)
 
 	var mu sync.Mutex
-	t.Cmd.Stdout = &outputHandler{t, t.Stdout, t.CombinedOutput, &mu}
-	t.Cmd.Stderr = &outputHandler{t, t.Stderr, t.CombinedOutput, &mu}
+	stdout := &outputHandler{task: t, writer: t.Stdout, copy: t.CombinedOutput, mu: &mu}
+	stderr := &outputHandler{task: t, writer: t.Stderr, copy: t.CombinedOutput, mu: &mu}
+	t.Cmd.Stdout = stdout
+	t.Cmd.Stderr = stderr
+
+	var tty *pty
+	if t.TTY {
+		tty, err = openPty()
+		if err != nil {
+			t.Status = Failed
+			return err
+		}
+		defer tty.master.Close()
+
+		t.Cmd.Stdout = tty.slave
+		t.Cmd.Stderr = tty.slave
+		if t.Cmd.Stdin == nil {
+			t.Cmd.Stdin = tty.slave
+		}
+		// The process of the task leads a new session and process group
+		// with the pty as its controlling terminal
+		t.Cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 2}
+	}
 
-	if err := t.Cmd.Start(); err != nil {
+	// Stdout streamed to the next task is not captured, but still counts as
+	// output when no_output_timeout is watched
+	if t.pipeOut != nil {
+		t.Cmd.Stdout = t.pipeOut
+		if t.NoOutputTimeout > 0 {
+			t.Cmd.Stdout = &touchWriter{task: t, writer: t.pipeOut}
+		}
+	}
+
+	if t.Interactive {
+		if err := t.attachTerminal(); err != nil {
+			t.Status = Failed
+			return err
+		}
+		defer pauseLogs()()
+	}
+
+	// The process is registered at once, so that it is not mistaken for a
+	// process leaked by another task
+	if err := running.start(t.Cmd); err != nil {
+		if tty != nil {
+			tty.slave.Close()
+		}
 		t.Status = Failed
 		return err
 	}
+	t.closeInheritedPipe()
+
+	ttyDone := make(chan struct{})
+	if tty != nil {
+		tty.slave.Close()
+		tty.watchResize(ttyDone)
+		go func() {
+			defer close(ttyDone)
+			io.Copy(stdout, tty.master)
+		}()
+	}
+
+	if gate != nil {
+		if err := t.releaseGate(gate); err != nil {
+			t.Cmd.Wait()
+			running.remove(t.Cmd.Process.Pid)
+			t.Status = Failed
+			cancel()
+			return err
+		}
+	}
 
 	t.Status = Running
+	t.touchOutput()
 
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	stalled := t.watchOutput(done)
 	go func(t *Task) {
-		for {
-			select {
-			case <-ctx.Done():
-				if t.Status == Running {
-					t.Status = Aborted
-					t.Cmd.Process.Kill()
-					pgid, err := syscall.Getpgid(t.Cmd.Process.Pid)
-					if err == nil {
-						syscall.Kill(-pgid, syscall.SIGTERM)
-					}
-					log.Warnf("[%s] aborted", t.Name)
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			if t.Status == Running {
+				t.Status = Aborted
+				t.stop(sig, done)
+				log.Warnf("[%s] aborted: %s", t.Name, t.Detail)
+			}
+		case <-stalled:
+			if t.Status == Running {
+				t.Status = Stalled
+				if t.DumpProcessTree {
+					log.Warnf("[%s] process tree:\n%s", t.Name, processTree(t.Cmd.Process.Pid))
 				}
-				return
+				t.stop(sig, done)
+				t.Detail = fmt.Sprintf("no output for %gs, %s", t.NoOutputTimeout, t.Detail)
+				log.Warnf("[%s] stalled: %s", t.Name, t.Detail)
 			}
+		case <-done:
 		}
 	}(t)
 
 	t.Cmd.Wait()
+	close(done)
+	<-stopped
 
-	if t.Cmd.ProcessState.Success() {
-		t.Status = Succeeded
-	} else if t.Status == Running {
-		t.Status = Failed
+	if tty != nil {
+		// Background processes may keep the pty open
+		select {
+		case <-ttyDone:
+		case <-time.After(time.Second):
+			tty.master.Close()
+			<-ttyDone
+		}
+	}
+
+	stdout.flush()
+	stderr.flush()
+
+	running.remove(t.Cmd.Process.Pid)
+	t.checkLeaked()
+
+	ws := t.Cmd.ProcessState.Sys().(syscall.WaitStatus)
+	t.ExitCode = ws.ExitStatus()
+	if ws.Signaled() {
+		t.Signal = signalName(ws.Signal())
+	}
+
+	if t.Status == Aborted {
+		return nil
+	}
+
+	if t.Status == Stalled {
 		cancel()
-		return errors.New("Task failed")
+		return fmt.Errorf("Task stalled: %s", t.Detail)
 	}
 
-	if t.Status == Succeeded {
+	switch {
+	case t.limitExceeded() != "":
+		t.Status = Failed
+		t.Detail = t.limitExceeded()
+		cancel()
+		return fmt.Errorf("Task failed because %s", t.Detail)
+	case t.rules.failure != "":
+		t.Status = Failed
+		t.Detail = "output matched fail_on: " + t.rules.failure
+		cancel()
+		return fmt.Errorf("Task failed because %s", t.Detail)
+	case t.Signal == "" && containsCode(t.SkipCodes, t.ExitCode):
+		t.Status = Skipped
+		t.Detail = fmt.Sprintf("exit code %d is in skip_codes", t.ExitCode)
+		log.Warnf("[%s] Skipped because %s", t.Name, t.Detail)
+	case t.Signal == "" && t.rules.success != "":
+		t.Status = Succeeded
+		t.Detail = "output matched succeed_on: " + t.rules.success
 		log.Infof("[%s] End task", t.Name)
+	case t.Signal == "" && t.succeeded():
+		t.Status = Succeeded
+		log.Infof("[%s] End task", t.Name)
+	default:
+		t.Status = Failed
+		cancel()
+		if t.Signal != "" {
+			return fmt.Errorf("Task killed by %s", t.Signal)
+		}
+		return fmt.Errorf("Task failed with exit code %d", t.ExitCode)
 	}
 
 	return nil
 }
 
+func (t *Task) succeeded() bool {
+	if len(t.SuccessCodes) == 0 {
+		return t.ExitCode == 0
+	}
+	return containsCode(t.SuccessCodes, t.ExitCode)
+}
+
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *outputHandler) Write(b []byte) (int, error) {
-	log.Infof("[%s] %s", o.task.Name, strings.TrimSuffix(string(b), "\n"))
+	o.task.touchOutput()
+	line := strings.TrimSuffix(string(b), "\n")
+	if o.task.StripANSI {
+		line = StripANSI(line)
+	}
+	log.Infof("[%s] %s", o.task.Name, line)
 
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	o.writer.Write(b)
 	o.copy.Write(b)
+	o.scanLines(b)
 
 	return len(b), nil
 }
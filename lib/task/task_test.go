@@ -1,9 +1,9 @@
 package task
 
 import (
-	"bytes"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -71,7 +71,7 @@ func TestStatus(t *testing.T) {
 	}
 }
 
-func contains(buf *bytes.Buffer, e string) bool {
+func contains(buf *Output, e string) bool {
 	for _, a := range strings.Split(buf.String(), "\n") {
 		if strings.Contains(a, e) {
 			return true
@@ -79,3 +79,125 @@ func contains(buf *bytes.Buffer, e string) bool {
 	}
 	return false
 }
+
+func TestStopSignal(t *testing.T) {
+	tsk := &Task{Name: "trap", Command: "trap 'exit 0' USR1; sleep 10 & wait", StopSignal: "USR1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		cancel()
+	}()
+
+	tsk.Run(ctx, cancel, nil)
+
+	if tsk.Status != Aborted {
+		t.Fatal("tsk.Status should be Aborted")
+	}
+
+	if tsk.Detail != "stopped by SIGUSR1" {
+		t.Fatalf("tsk.Detail should be \"stopped by SIGUSR1\", not %q", tsk.Detail)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	tsk := &Task{Name: "ignore", Command: "trap '' TERM; sleep 10", StopTimeout: 0.5}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	tsk.Run(ctx, cancel, nil)
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("task should have been killed after stop_timeout")
+	}
+
+	if !strings.HasPrefix(tsk.Detail, "stopped by SIGKILL") {
+		t.Fatalf("tsk.Detail should start with \"stopped by SIGKILL\", not %q", tsk.Detail)
+	}
+}
+
+func TestInvalidStopSignal(t *testing.T) {
+	tsk := &Task{Name: "invalid", Command: "echo foo", StopSignal: "SIGFOO"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tsk := &Task{Name: "not found", Command: "no_such_command"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tsk.Run(ctx, cancel, nil)
+
+	if tsk.ExitCode != 127 {
+		t.Fatalf("tsk.ExitCode should be 127, not %d", tsk.ExitCode)
+	}
+
+	tsk = &Task{Name: "killed", Command: "kill -KILL $$"}
+	ctx, cancel = context.WithCancel(context.Background())
+	tsk.Run(ctx, cancel, nil)
+
+	if tsk.Signal != "SIGKILL" {
+		t.Fatalf("tsk.Signal should be SIGKILL, not %q", tsk.Signal)
+	}
+}
+
+func TestSuccessCodes(t *testing.T) {
+	tsk := &Task{Name: "success", Command: "exit 3", SuccessCodes: []int{0, 3}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Status != Succeeded {
+		t.Fatal("tsk.Status should be Succeeded")
+	}
+
+	tsk = &Task{Name: "fail", Command: "exit 0", SuccessCodes: []int{3}}
+	err = tsk.Run(ctx, cancel, nil)
+	if err == nil {
+		t.Fatal("tsk.Run() should return err")
+	}
+}
+
+func TestSkipCodes(t *testing.T) {
+	tsk := &Task{Name: "skip", Command: "exit 78", SkipCodes: []int{78}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Status != Skipped {
+		t.Fatal("tsk.Status should be Skipped")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	tsk := &Task{Name: "usage", Command: "sleep 0.2"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tsk.Run(ctx, cancel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tsk.Usage.WallTime < 200*time.Millisecond {
+		t.Fatalf("tsk.Usage.WallTime should be longer than 200ms, not %s", tsk.Usage.WallTime)
+	}
+
+	if tsk.Usage.MaxRSS == 0 {
+		t.Fatal("tsk.Usage.MaxRSS should be recorded")
+	}
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Usage is resource usage of a task. Usage of parallel and serial tasks is
+// the sum of CPU times and the maximum of max RSS of their children.
+type Usage struct {
+	WallTime time.Duration `json:"wall_time"`
+	UserTime time.Duration `json:"user_time"`
+	SysTime  time.Duration `json:"sys_time"`
+	MaxRSS   int64         `json:"max_rss"`
+}
+
+func (t *Task) recordUsage(start time.Time) {
+	t.Usage.WallTime = time.Since(start)
+	if t.Cmd == nil || t.Cmd.ProcessState == nil {
+		return
+	}
+
+	t.Usage.UserTime = t.Cmd.ProcessState.UserTime()
+	t.Usage.SysTime = t.Cmd.ProcessState.SystemTime()
+
+	if ru, ok := t.Cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		// ru_maxrss is in kilobytes on Linux and in bytes on macOS
+		t.Usage.MaxRSS = int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" {
+			t.Usage.MaxRSS *= 1024
+		}
+	}
+}
+
+// Add adds CPU times of c to the usage and keeps the larger max RSS.
+func (u *Usage) Add(c Usage) {
+	u.UserTime += c.UserTime
+	u.SysTime += c.SysTime
+	if c.MaxRSS > u.MaxRSS {
+		u.MaxRSS = c.MaxRSS
+	}
+}
@@ -3,69 +3,308 @@ package task
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"golang.org/x/net/context"
+
 	log "github.com/Sirupsen/logrus"
 )
 
+const (
+	defaultWaitInterval    = 100 * time.Millisecond
+	defaultWaitMaxInterval = 10 * time.Second
+)
+
+// WaitFor defines a condition a task waits for before it starts, or a list
+// of conditions combined with All or Any. A list of conditions given
+// directly to wait_for means All.
 type WaitFor struct {
-	Host  string
-	Port  int
-	File  string
-	State string
-	Delay float64
+	Host            string
+	Port            int
+	File            string
+	State           string
+	Delay           float64
+	URL             string `yaml:"url"`
+	Method          string
+	ExpectStatus    int    `yaml:"expect_status"`
+	ExpectBodyRegex string `yaml:"expect_body_regex"`
+	Headers         map[string]string
+	InsecureTLS     bool `yaml:"insecure_tls"`
+	Command         string
+	Log             *LogCondition
+	All             []*WaitFor
+	Any             []*WaitFor
+	Timeout         float64
+	Interval        float64
+	Backoff         float64
+	MaxInterval     float64 `yaml:"max_interval"`
+	Socket          string
+	DNS             string `yaml:"dns"`
+	ContentRegex    string `yaml:"content_regex"`
+	Process         string
+	Pidfile         string
+}
+
+func (w *WaitFor) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var all []*WaitFor
+	if err := unmarshal(&all); err == nil {
+		w.All = all
+		return nil
+	}
+
+	type plain WaitFor
+	return unmarshal((*plain)(w))
+}
+
+// errNotReady is returned when conditions did not reach their state
+// within their timeout.
+type errNotReady struct {
+	conditions string
+}
+
+func (e *errNotReady) Error() string {
+	return "wait_for: gave up waiting for " + e.conditions
 }
 
-func (t *Task) wait() error {
+func (t *Task) wait(ctx context.Context, cancel context.CancelFunc) error {
 	err := t.WaitFor.validate()
 	if err != nil {
+		t.Status = Failed
+		return err
+	}
+
+	err = t.WaitFor.wait(ctx, t)
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		t.Status = Aborted
+		t.Detail = "aborted while waiting for " + t.WaitFor.String()
+		log.Warnf("[%s] %s", t.Name, t.Detail)
+		return nil
+	default:
+		t.Status = Failed
+		t.Detail = err.Error()
+		cancel()
 		return err
 	}
-	t.WaitFor.wait(t)
-	return nil
 }
 
-func (w *WaitFor) wait(t *Task) {
+func (w *WaitFor) wait(ctx context.Context, t *Task) error {
+	if w.Timeout > 0.0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, seconds(w.Timeout))
+		defer cancel()
+	}
+
 	switch {
+	case len(w.All) > 0:
+		return w.waitForAll(ctx, t)
+	case len(w.Any) > 0:
+		return w.waitForAny(ctx, t)
 	case w.Delay > 0.0:
-		w.waitForDelay(t)
+		return w.waitForDelay(ctx, t)
+	}
+
+	log.Infof("[%s] wait_for: %s %s", t.Name, w, w.State)
+	return w.poll(ctx, w.check(ctx, t))
+}
+
+func (w *WaitFor) check(ctx context.Context, t *Task) func() bool {
+	switch {
 	case w.Port > 0:
-		w.waitForPort(t)
+		return func() bool { return connected(w.Host, w.Port) }
+	case w.File != "" && w.ContentRegex != "":
+		re := regexp.MustCompile(w.ContentRegex)
+		return func() bool { return fileMatches(w.File, re) }
 	case w.File != "":
-		w.waitForFile(t)
+		return func() bool { return isExist(w.File) }
+	case w.Socket != "":
+		return func() bool { return accepting(w.Socket) }
+	case w.DNS != "":
+		return func() bool { return resolved(w.DNS) }
+	case w.Process != "":
+		return func() bool { return processRunning(w.Process) }
+	case w.Pidfile != "":
+		return func() bool { return pidfileAlive(w.Pidfile) }
+	case w.URL != "":
+		client := w.httpClient()
+		return func() bool { return w.healthy(ctx, client) }
+	case w.Command != "":
+		return func() bool { return probe(ctx, w.Command, t.Directory) }
+	case w.Log != nil:
+		return w.Log.matcher(regexp.MustCompile(w.Log.Regex))
 	}
+	return func() bool { return true }
 }
 
-func (w *WaitFor) waitForDelay(t *Task) {
-	log.Infof("[%s] wait_for: %f seconds delay", t.Name, w.Delay)
-	time.Sleep(time.Duration(w.Delay) * time.Second)
-	return
-}
-
-func (w *WaitFor) waitForPort(t *Task) {
-	log.Infof("[%s] wait_for: %s:%d %s", t.Name, w.Host, w.Port, w.State)
-	if w.State == "ready" || w.State == "present" {
-		for {
-			if connected(w.Host, w.Port) {
-				return
-			} else {
-				time.Sleep(10 * time.Millisecond)
+// poll calls check until it reports the state waited for. The interval
+// between checks grows by Backoff up to MaxInterval.
+func (w *WaitFor) poll(ctx context.Context, check func() bool) error {
+	ready := w.State == "ready" || w.State == "present"
+
+	interval := defaultWaitInterval
+	if w.Interval > 0.0 {
+		interval = seconds(w.Interval)
+	}
+	max := defaultWaitMaxInterval
+	if w.MaxInterval > 0.0 {
+		max = seconds(w.MaxInterval)
+	}
+
+	for {
+		// A check finishing after the timeout does not count
+		if check() == ready && ctx.Err() == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return &errNotReady{fmt.Sprintf("%s to be %s", w, w.State)}
+		case <-time.After(interval):
+		}
+
+		if w.Backoff > 1.0 {
+			interval = time.Duration(float64(interval) * w.Backoff)
+			if interval > max {
+				interval = max
 			}
 		}
 	}
+}
+
+func (w *WaitFor) waitForDelay(ctx context.Context, t *Task) error {
+	log.Infof("[%s] wait_for: %f seconds delay", t.Name, w.Delay)
+	select {
+	case <-ctx.Done():
+		return &errNotReady{w.String()}
+	case <-time.After(seconds(w.Delay)):
+		return nil
+	}
+}
+
+// waitForAll waits for the conditions concurrently and gives up on all of
+// them as soon as one of them fails.
+func (w *WaitFor) waitForAll(ctx context.Context, t *Task) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	if w.State == "unready" || w.State == "absent" {
-		for {
-			if !connected(w.Host, w.Port) {
-				return
-			} else {
-				time.Sleep(10 * time.Millisecond)
+	errs := make(chan error, len(w.All))
+	for _, c := range w.All {
+		go func(c *WaitFor) {
+			err := c.wait(ctx, t)
+			// Send the error before giving up on the other conditions,
+			// so that it is received first
+			errs <- err
+			if err != nil {
+				cancel()
 			}
+		}(c)
+	}
+
+	var failed []string
+	for range w.All {
+		err := <-errs
+		if err == nil {
+			continue
+		}
+		notReady, ok := err.(*errNotReady)
+		if !ok {
+			return err
+		}
+		// Conditions given up on because another one failed are not
+		// reported, unless the whole wait timed out or was cancelled
+		if len(failed) == 0 || parent.Err() != nil {
+			failed = append(failed, notReady.conditions)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &errNotReady{strings.Join(failed, " and ")}
+	}
+	return nil
+}
+
+// waitForAny waits for the conditions concurrently until one of them
+// succeeds.
+func (w *WaitFor) waitForAny(ctx context.Context, t *Task) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(w.Any))
+	for _, c := range w.Any {
+		go func(c *WaitFor) {
+			errs <- c.wait(ctx, t)
+		}(c)
+	}
+
+	var failed []string
+	for range w.Any {
+		err := <-errs
+		if err == nil {
+			return nil
 		}
+		notReady, ok := err.(*errNotReady)
+		if !ok {
+			return err
+		}
+		failed = append(failed, notReady.conditions)
+	}
+	return &errNotReady{strings.Join(failed, " or ")}
+}
+
+func (w *WaitFor) String() string {
+	switch {
+	case len(w.All) > 0:
+		return "all of " + joinConditions(w.All)
+	case len(w.Any) > 0:
+		return "any of " + joinConditions(w.Any)
+	case w.Delay > 0.0:
+		return fmt.Sprintf("delay of %gs", w.Delay)
+	case w.Port > 0:
+		return fmt.Sprintf("port %s:%d", w.Host, w.Port)
+	case w.File != "" && w.ContentRegex != "":
+		return fmt.Sprintf("file %s matching %q", w.File, w.ContentRegex)
+	case w.File != "":
+		return "file " + w.File
+	case w.Socket != "":
+		return "socket " + w.Socket
+	case w.DNS != "":
+		return "dns " + w.DNS
+	case w.Process != "":
+		return "process " + w.Process
+	case w.Pidfile != "":
+		return "pidfile " + w.Pidfile
+	case w.URL != "":
+		return "url " + w.URL
+	case w.Command != "":
+		return fmt.Sprintf("command %q", w.Command)
+	case w.Log != nil && w.Log.File != "":
+		return fmt.Sprintf("log of file %s matching %q", w.Log.File, w.Log.Regex)
+	case w.Log != nil:
+		return fmt.Sprintf("log of task %s matching %q", w.Log.Task, w.Log.Regex)
 	}
+	return "nothing"
+}
+
+func joinConditions(conditions []*WaitFor) string {
+	var s []string
+	for _, c := range conditions {
+		s = append(s, c.String())
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
 
 func connected(host string, port int) bool {
@@ -77,42 +316,117 @@ func connected(host string, port int) bool {
 	return true
 }
 
-func (w *WaitFor) waitForFile(t *Task) {
-	log.Infof("[%s] wait_for: file %s %s", t.Name, w.File, w.State)
-	if w.State == "ready" || w.State == "present" {
-		for {
-			if isExist(w.File) {
-				return
-			} else {
-				time.Sleep(10 * time.Millisecond)
-			}
-		}
+func isExist(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+func fileMatches(file string, re *regexp.Regexp) bool {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
 	}
+	return re.Match(b)
+}
 
-	if w.State == "unready" || w.State == "absent" {
-		for {
-			if !isExist(w.File) {
-				return
-			} else {
-				time.Sleep(10 * time.Millisecond)
-			}
-		}
+func accepting(socket string) bool {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	return true
+}
+
+func resolved(host string) bool {
+	addrs, err := net.LookupHost(host)
+	return err == nil && len(addrs) > 0
+}
+
+// pidfileAlive reports whether the process of the pid written in pidfile
+// exists.
+func pidfileAlive(pidfile string) bool {
+	b, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return false
 	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	err = syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
 }
 
-func isExist(file string) bool {
-	_, err := os.Stat(file)
-	return err == nil
+// conditions returns the names of the conditions set in w.
+func (w *WaitFor) conditions() []string {
+	var c []string
+	if w.Port != 0 {
+		c = append(c, "port")
+	}
+	if w.File != "" {
+		c = append(c, "file")
+	}
+	if w.Delay > 0.0 {
+		c = append(c, "delay")
+	}
+	if w.URL != "" {
+		c = append(c, "url")
+	}
+	if w.Command != "" {
+		c = append(c, "command")
+	}
+	if w.Log != nil {
+		c = append(c, "log")
+	}
+	if w.All != nil {
+		c = append(c, "all")
+	}
+	if w.Any != nil {
+		c = append(c, "any")
+	}
+	if w.Socket != "" {
+		c = append(c, "socket")
+	}
+	if w.DNS != "" {
+		c = append(c, "dns")
+	}
+	if w.Process != "" {
+		c = append(c, "process")
+	}
+	if w.Pidfile != "" {
+		c = append(c, "pidfile")
+	}
+	return c
 }
 
 func (w *WaitFor) validate() error {
 	switch {
-	case w.Port != 0 && w.File != "":
-		return errors.New("wait_for: cannot use port and file at the same time")
-	case w.Port != 0 && w.Delay > 0.0:
-		return errors.New("wait_for: cannot use port and delay at the same time")
-	case w.File != "" && w.Delay > 0.0:
-		return errors.New("wait_for: cannot use file and delay at the same time")
+	case len(w.conditions()) > 1:
+		return errors.New("wait_for: cannot use " + strings.Join(w.conditions(), " and ") + " at the same time")
+	case w.Timeout < 0:
+		return errors.New("wait_for: timeout must be positive")
+	case w.Interval < 0:
+		return errors.New("wait_for: interval must be positive")
+	case w.MaxInterval < 0:
+		return errors.New("wait_for: max_interval must be positive")
+	case w.Backoff != 0 && w.Backoff < 1:
+		return errors.New("wait_for: backoff must be 1 or greater")
+	}
+
+	if w.All != nil || w.Any != nil {
+		return w.validateGroup()
+	}
+
+	switch {
+	case w.URL == "" && (w.Method != "" || w.ExpectStatus != 0 || w.ExpectBodyRegex != "" || len(w.Headers) > 0 || w.InsecureTLS):
+		return errors.New("wait_for: cannot use method, expect_status, expect_body_regex, headers or insecure_tls without url")
+	case w.URL != "" && !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://"):
+		return errors.New("wait_for: url must start with http:// or https://")
+	case w.URL != "" && w.State == "":
+		return errors.New("wait_for: cannot use url without state")
+	case w.ExpectStatus < 0:
+		return errors.New("wait_for: expect_status must be positive")
 	case w.Delay < 0:
 		return errors.New("wait_for: delay must be positive")
 	case w.Port < 0:
@@ -127,8 +441,60 @@ func (w *WaitFor) validate() error {
 		return errors.New("wait_for: cannot use port without state")
 	case w.File != "" && w.State == "":
 		return errors.New("wait_for: cannot use file without state")
+	case w.Command != "" && w.State == "":
+		return errors.New("wait_for: cannot use command without state")
+	case w.Log != nil && w.State == "":
+		return errors.New("wait_for: cannot use log without state")
+	case (w.Socket != "" || w.DNS != "" || w.Process != "" || w.Pidfile != "") && w.State == "":
+		return errors.New("wait_for: cannot use " + w.conditions()[0] + " without state")
+	case w.ContentRegex != "" && w.File == "":
+		return errors.New("wait_for: cannot use content_regex without file")
+	case w.Log != nil && (w.Log.File == "") == (w.Log.Task == ""):
+		return errors.New("wait_for: log must have either file or task")
+	case w.Log != nil && w.Log.Regex == "":
+		return errors.New("wait_for: cannot use log without regex")
+	}
+
+	if w.Log != nil {
+		if _, err := regexp.Compile(w.Log.Regex); err != nil {
+			return errors.New("wait_for: log regex: " + err.Error())
+		}
+	}
+
+	if w.ContentRegex != "" {
+		if _, err := regexp.Compile(w.ContentRegex); err != nil {
+			return errors.New("wait_for: content_regex: " + err.Error())
+		}
+	}
+
+	if w.ExpectBodyRegex != "" {
+		if _, err := regexp.Compile(w.ExpectBodyRegex); err != nil {
+			return errors.New("wait_for: expect_body_regex: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (w *WaitFor) validateGroup() error {
+	conditions := append(append([]*WaitFor{}, w.All...), w.Any...)
+	switch {
+	case len(conditions) == 0:
+		return errors.New("wait_for: all and any must have at least one condition")
+	case w.Host != "" || w.State != "" || w.ContentRegex != "" || w.Method != "" || w.ExpectStatus != 0 || w.ExpectBodyRegex != "" || len(w.Headers) > 0 || w.InsecureTLS:
+		return errors.New("wait_for: cannot use all or any with the keys of a condition")
+	case w.Interval != 0 || w.Backoff != 0 || w.MaxInterval != 0:
+		return errors.New("wait_for: interval, backoff and max_interval must be set on each condition of all or any")
 	}
 
+	for _, c := range conditions {
+		if c == nil {
+			return errors.New("wait_for: empty condition")
+		}
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,36 @@
+package task
+
+import (
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// probe runs command and reports whether it exited with 0 before ctx is
+// done. The process group of the command is killed when ctx is done.
+func probe(ctx context.Context, command, dir string) bool {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := running.start(cmd); err != nil {
+		return false
+	}
+	defer running.remove(cmd.Process.Pid)
+
+	done := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		defer close(killed)
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	<-killed
+	return err == nil && ctx.Err() == nil
+}
@@ -0,0 +1,130 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// LogCondition waits for a line matching Regex in File or in the output of
+// Task, which usually runs in the background in the same parallel block.
+type LogCondition struct {
+	File  string
+	Task  string
+	Regex string
+}
+
+// outputs holds the combined output of the tasks started in the current run
+// by name, so that other tasks can wait for their log lines.
+var outputs = struct {
+	mu    sync.Mutex
+	tasks map[string]*Output
+}{tasks: map[string]*Output{}}
+
+func registerOutput(name string, o *Output) {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	outputs.tasks[name] = o
+}
+
+func taskOutput(name string) *Output {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	return outputs.tasks[name]
+}
+
+func forgetOutputs() {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	outputs.tasks = map[string]*Output{}
+}
+
+// logMatcher looks for a line matching re in what was logged since the
+// previous check.
+type logMatcher struct {
+	re     *regexp.Regexp
+	output *Output
+	offset int64
+	line   []byte
+	found  bool
+}
+
+func (m *logMatcher) reset() {
+	m.offset, m.line, m.found = 0, nil, false
+}
+
+// match reads data, which was logged after the previous data, and reports
+// whether a line matching re was logged so far.
+func (m *logMatcher) match(data []byte) bool {
+	m.offset += int64(len(data))
+	if m.found {
+		return true
+	}
+
+	lines := append(m.line, data...)
+	for {
+		i := bytes.IndexByte(lines, '\n')
+		if i < 0 {
+			break
+		}
+		if m.re.Match(lines[:i]) {
+			m.found = true
+			return true
+		}
+		lines = lines[i+1:]
+	}
+	m.line = append([]byte{}, lines...)
+
+	// The last line may still be being written
+	return m.re.Match(m.line)
+}
+
+// matcher returns a check reporting whether a line matching re was logged.
+// Each check reads only what was logged since the previous one, and starts
+// over when the file is truncated or the task is started again.
+func (l *LogCondition) matcher(re *regexp.Regexp) func() bool {
+	m := &logMatcher{re: re}
+	return func() bool {
+		data, err := l.read(m)
+		if err != nil {
+			m.reset()
+			return false
+		}
+		return m.match(data)
+	}
+}
+
+func (l *LogCondition) read(m *logMatcher) ([]byte, error) {
+	if l.File == "" {
+		o := taskOutput(l.Task)
+		if o == nil {
+			return nil, errors.New("no output of " + l.Task)
+		}
+		if o != m.output {
+			m.output = o
+			m.reset()
+		}
+		return o.Since(m.offset)
+	}
+
+	f, err := os.Open(l.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < m.offset {
+		m.reset()
+	}
+	if _, err := f.Seek(m.offset, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
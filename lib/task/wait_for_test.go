@@ -1,6 +1,24 @@
 package task
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"golang.org/x/net/context"
+)
 
 func TestValidateWaitFor(t *testing.T) {
 	w := &WaitFor{}
@@ -19,3 +37,503 @@ func TestValidateWaitFor(t *testing.T) {
 		t.Fatalf("Error should be returned: %#v", w)
 	}
 }
+
+func TestValidateWaitForURL(t *testing.T) {
+	w := &WaitFor{URL: "http://localhost", Port: 80, State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{URL: "localhost", State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{URL: "http://localhost"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{ExpectStatus: 200, Port: 80, Host: "localhost", State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{URL: "http://localhost", State: "ready", ExpectBodyRegex: "("}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{URL: "https://localhost/health", State: "ready", ExpectStatus: 204, InsecureTLS: true}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForURL(t *testing.T) {
+	var requests int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"status": "ok"}`)
+	}))
+	defer ts.Close()
+
+	w := &WaitFor{
+		URL:             ts.URL,
+		State:           "ready",
+		ExpectStatus:    200,
+		ExpectBodyRegex: `"status": "ok"`,
+		Headers:         map[string]string{"X-Token": "secret"},
+		InsecureTLS:     true,
+	}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.wait(context.Background(), &Task{Name: "url"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&requests) < 3 {
+		t.Fatal("wait_for should have polled until the url became ready")
+	}
+
+	ts.Close()
+	w.State = "unready"
+	if err := w.wait(context.Background(), &Task{Name: "url"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForURLConnections(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	w := &WaitFor{URL: ts.URL, State: "ready", ExpectBodyRegex: "ok"}
+	check := w.check(context.Background(), &Task{Name: "url"})
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		if !check() {
+			t.Fatal("url should be healthy")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n > before+5 {
+		t.Fatalf("polls should not leave connections open: %d goroutines, %d before", n, before)
+	}
+}
+
+func TestWaitForURLAborted(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	w := &WaitFor{URL: ts.URL, State: "ready"}
+	start := time.Now()
+	if err := w.wait(ctx, &Task{Name: "url"}); err == nil {
+		t.Fatal("Error should be returned")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("wait_for should stop polling as soon as it is aborted")
+	}
+}
+
+func TestValidateWaitForCommandAndLog(t *testing.T) {
+	w := &WaitFor{Command: "true", Port: 80, Host: "localhost", State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Log: &LogCondition{File: "server.log", Task: "server", Regex: "up"}, State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Log: &LogCondition{Task: "server"}, State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Log: &LogCondition{Task: "server", Regex: "up"}}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Command: "pg_isready", State: "ready"}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter-wait-for")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(dir, "ready"), nil, 0644)
+	}()
+
+	start := time.Now()
+	w := &WaitFor{Command: "test -f ready", State: "ready"}
+	if err := w.wait(context.Background(), &Task{Name: "command", Directory: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("wait_for should have waited until the command succeeded")
+	}
+
+	w.State = "unready"
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		os.Remove(filepath.Join(dir, "ready"))
+	}()
+	if err := w.wait(context.Background(), &Task{Name: "command", Directory: dir}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForLog(t *testing.T) {
+	f, err := ioutil.TempFile("", "walter-wait-for")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprintln(f, "listening on :8080")
+	}()
+
+	start := time.Now()
+	w := &WaitFor{Log: &LogCondition{File: f.Name(), Regex: `listening on :\d+`}, State: "ready"}
+	if err := w.wait(context.Background(), &Task{Name: "log"}); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("wait_for should have waited until the line was logged")
+	}
+
+	server := &Task{Name: "log-server", Command: "echo starting; sleep 0.3; echo listening on :8080; sleep 0.3"}
+	go server.Run(context.Background(), func() {}, nil)
+
+	start = time.Now()
+	w = &WaitFor{Log: &LogCondition{Task: "log-server", Regex: `listening on`}, State: "ready"}
+	if err := w.wait(context.Background(), &Task{Name: "log"}); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("wait_for should have waited until the task logged the line")
+	}
+}
+
+func TestLogMatcher(t *testing.T) {
+	o := NewOutput()
+	registerOutput("log-matcher", o)
+	check := (&LogCondition{Task: "log-matcher"}).matcher(regexp.MustCompile(`^ready$`))
+
+	o.Write([]byte("starting\nrea"))
+	if check() {
+		t.Fatal("no line should match yet")
+	}
+	o.Write([]byte("dy\n"))
+	if !check() {
+		t.Fatal("the line written across two checks should match")
+	}
+
+	// A task started again is read from the beginning
+	registerOutput("log-matcher", NewOutput())
+	if check() {
+		t.Fatal("the output of the task started again should not match")
+	}
+
+	RemoveOutputs()
+	if taskOutput("log-matcher") != nil {
+		t.Fatal("outputs should be forgotten after the run")
+	}
+}
+
+func TestValidateWaitForGroup(t *testing.T) {
+	w := &WaitFor{All: []*WaitFor{}}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{All: []*WaitFor{{File: "/tmp"}}, Any: []*WaitFor{{File: "/tmp"}}}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Any: []*WaitFor{{File: "/tmp", State: "present"}}, Interval: 1}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Any: []*WaitFor{{File: "/tmp"}}}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{File: "/tmp", State: "present", Backoff: 0.5}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{
+		Timeout: 60,
+		All: []*WaitFor{
+			{File: "/tmp", State: "present", Interval: 0.5, Backoff: 2, MaxInterval: 5},
+			{Any: []*WaitFor{{Host: "localhost", Port: 80, State: "ready"}, {Delay: 1}}},
+		},
+	}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnmarshalWaitFor(t *testing.T) {
+	var task Task
+	err := yaml.Unmarshal([]byte(`
+wait_for:
+  - file: /tmp
+    state: present
+    timeout: 10
+  - any:
+      - host: localhost
+        port: 80
+        state: ready
+      - command: "true"
+        state: ready
+`), &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := task.WaitFor
+	if len(w.All) != 2 || w.All[0].Timeout != 10 || len(w.All[1].Any) != 2 || w.All[1].Any[1].Command != "true" {
+		t.Fatalf("wait_for is not unmarshaled: %#v", w)
+	}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForAnyAndAll(t *testing.T) {
+	w := &WaitFor{Any: []*WaitFor{
+		{File: "/walter-does-not-exist", State: "present"},
+		{Command: "true", State: "ready"},
+	}}
+	if err := w.wait(context.Background(), &Task{Name: "any"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w = &WaitFor{All: []*WaitFor{
+		{File: "/walter-does-not-exist", State: "present", Timeout: 0.2},
+		{Command: "true", State: "ready"},
+	}}
+	err := w.wait(context.Background(), &Task{Name: "all"})
+	if err == nil || !strings.Contains(err.Error(), "file /walter-does-not-exist to be present") {
+		t.Fatalf("wait_for should fail naming the condition: %v", err)
+	}
+
+	w = &WaitFor{All: []*WaitFor{
+		{File: "/walter-does-not-exist", State: "present", Timeout: 0.2},
+		{Command: "false", State: "ready"},
+	}}
+	err = w.wait(context.Background(), &Task{Name: "all"})
+	if err == nil || strings.Contains(err.Error(), "command") {
+		t.Fatalf("wait_for should only name the condition which failed: %v", err)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	task := &Task{
+		Name:    "timeout",
+		Command: "echo should not run",
+		WaitFor: &WaitFor{Command: "false", State: "ready", Timeout: 0.3, Interval: 0.05, Backoff: 2},
+	}
+
+	cancelled := false
+	err := task.Run(context.Background(), func() { cancelled = true }, nil)
+	if err == nil {
+		t.Fatal("Error should be returned")
+	}
+	if task.Status != Failed || !cancelled {
+		t.Fatalf("Task should fail and cancel the pipeline: %s", task.StatusName())
+	}
+	if !strings.Contains(task.Detail, `command "false" to be ready`) {
+		t.Fatalf("Detail should name the condition: %s", task.Detail)
+	}
+	if task.Stdout != nil {
+		t.Fatal("Command should not run")
+	}
+}
+
+func TestWaitForTimeoutWithHangingProbe(t *testing.T) {
+	task := &Task{
+		Name:    "hanging",
+		Command: "echo should not run",
+		WaitFor: &WaitFor{Command: "sleep 3", State: "ready", Timeout: 0.3},
+	}
+
+	start := time.Now()
+	err := task.Run(context.Background(), func() {}, nil)
+	if err == nil {
+		t.Fatal("Error should be returned")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("The probe should be killed when the timeout expires")
+	}
+	if task.Status != Failed {
+		t.Fatalf("Task should fail: %s", task.StatusName())
+	}
+}
+
+func TestWaitForAborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	task := &Task{
+		Name:    "aborted",
+		Command: "echo should not run",
+		WaitFor: &WaitFor{File: "/walter-does-not-exist", State: "present"},
+	}
+	if err := task.Run(ctx, cancel, nil); err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != Aborted {
+		t.Fatalf("Task should be aborted: %s", task.StatusName())
+	}
+}
+
+func TestValidateWaitForProbes(t *testing.T) {
+	w := &WaitFor{Socket: "/tmp/app.sock", DNS: "localhost", State: "ready"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{Process: "nginx"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{ContentRegex: "READY", Pidfile: "/tmp/app.pid", State: "present"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{File: "/tmp/app.log", ContentRegex: "(", State: "present"}
+	if w.validate() == nil {
+		t.Fatalf("Error should be returned: %#v", w)
+	}
+
+	w = &WaitFor{File: "/tmp/app.log", ContentRegex: "READY", State: "present"}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForProbes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walter-wait-for")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "app.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	file := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(file, []byte("starting\nREADY\n"), 0644)
+
+	pidfile := filepath.Join(dir, "app.pid")
+	ioutil.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+
+	conditions := []*WaitFor{
+		{Socket: socket, State: "ready"},
+		{DNS: "localhost", State: "ready"},
+		{File: file, ContentRegex: "(?m)^READY$", State: "present"},
+		{Pidfile: pidfile, State: "present"},
+		{Pidfile: filepath.Join(dir, "missing.pid"), State: "absent"},
+		{Process: "walter-does-not-exist", State: "absent"},
+		{Socket: filepath.Join(dir, "missing.sock"), State: "unready"},
+	}
+	for _, w := range conditions {
+		w.Timeout = 5
+		if err := w.validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.wait(context.Background(), &Task{Name: "probe"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWaitForProcess(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "walter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A name no other process has
+	name := fmt.Sprintf("sleep-%d", time.Now().UnixNano()%1000000)
+	if err := os.Symlink(sleep, filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &WaitFor{Process: name, State: "present", Timeout: 0.3}
+	if err := w.wait(context.Background(), &Task{Name: "process"}); err == nil {
+		t.Fatal("wait_for should fail before the process is started")
+	}
+
+	cmd := exec.Command(filepath.Join(dir, name), "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+
+	w = &WaitFor{Process: name, State: "present", Timeout: 5}
+	if err := w.wait(context.Background(), &Task{Name: "process"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.Process.Kill()
+	w = &WaitFor{Process: name, State: "absent", Timeout: 5}
+	if err := w.wait(context.Background(), &Task{Name: "process"}); err != nil {
+		t.Fatal(err)
+	}
+}
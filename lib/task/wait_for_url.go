@@ -0,0 +1,69 @@
+package task
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const urlTimeout = 5 * time.Second
+
+// httpClient returns the client polling the url. Connections are not kept
+// alive, so nothing is left open between polls or after the wait.
+func (w *WaitFor) httpClient() *http.Client {
+	return &http.Client{
+		Timeout: urlTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: w.InsecureTLS},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// healthy reports whether the url responds with the expected status and
+// body. Without expect_status, any 2xx status is expected.
+func (w *WaitFor) healthy(ctx context.Context, client *http.Client) bool {
+	method := w.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	req, err := http.NewRequest(method, w.URL, nil)
+	if err != nil {
+		return false
+	}
+	req = req.WithContext(ctx)
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if host, ok := w.Headers["Host"]; ok {
+		req.Host = host
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if w.ExpectStatus != 0 && resp.StatusCode != w.ExpectStatus {
+		return false
+	}
+	if w.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return false
+	}
+
+	if w.ExpectBodyRegex == "" {
+		return true
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+	return regexp.MustCompile(w.ExpectBodyRegex).Match(body)
+}
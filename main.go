@@ -7,22 +7,33 @@ import (
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/walter-cd/walter/lib/pipeline"
+	"github.com/walter-cd/walter/lib/task"
 )
 
+const defaultConfigFile = "pipeline.yml"
+
 func main() {
-	const defaultConfigFile = "pipeline.yml"
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
 
 	var (
-		configFile string
-		version    bool
-		build      bool
-		deploy     bool
+		configFile   string
+		version      bool
+		build        bool
+		deploy       bool
+		jobs         int
+		changedSince string
+		offline      bool
 	)
 
 	flag.StringVar(&configFile, "config", defaultConfigFile, "file which define pipeline")
 	flag.BoolVar(&version, "version", false, "print version string")
 	flag.BoolVar(&build, "build", false, "run build")
 	flag.BoolVar(&deploy, "deploy", false, "run deploy")
+	flag.IntVar(&jobs, "jobs", 0, "maximum number of tasks running at the same time (0 means unlimited)")
+	flag.StringVar(&changedSince, "changed-since", "", "git ref which changes of tasks are compared with")
+	flag.BoolVar(&offline, "offline", false, "read remote includes from the cache only")
 
 	flag.Parse()
 
@@ -36,10 +47,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := task.EnableSubreaper(); err != nil {
+		log.Warnf("Failed to register as a child subreaper: %s", err)
+	}
+
+	pipeline.Offline = offline
 	p, err := pipeline.LoadFromFile(configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	p.Jobs = jobs
+	p.ChangedSince = changedSince
 	os.Exit(p.Run(build, deploy))
 }